@@ -0,0 +1,45 @@
+package grpcweb
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// accessLog writes a line for each gRPC-Web request handled.
+type accessLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithAccessLog writes a line to w for each gRPC-Web request handled, once
+// its response has completed, in the format:
+//
+//	2006-01-02T15:04:05Z07:00 /package.Service/Method application/grpc-web-text text_request=true text_response=true bytes=123 grpc_status=OK duration=1.5ms
+//
+// The bytes are those of the response's frames, including the trailer frame,
+// counted before any base64 encoding. Lines are written whole, so w can be
+// shared with other handlers.
+func WithAccessLog(w io.Writer) Option {
+	return func(o *options) {
+		o.accessLog = &accessLog{w: w}
+	}
+}
+
+// record writes the access log line of a request.
+func (l *accessLog) record(start time.Time, info RequestInfo, code codes.Code) {
+	if l == nil {
+		return
+	}
+
+	line := fmt.Sprintf("%s %s %s text_request=%t text_response=%t bytes=%d grpc_status=%s duration=%s\n",
+		start.Format(time.RFC3339), info.Method, info.ContentType, info.TextRequest, info.TextResponse, info.DecodedBytesOut, code, time.Since(start))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	io.WriteString(l.w, line)
+}
@@ -0,0 +1,28 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		resp.Write([]byte{0, 0, 0, 0, 2, 8, 1})
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+	}), grpcweb.WithAccessLog(buf))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the message frame of 7 bytes, and the trailer frame of 5+16 bytes
+	assert.Regexp(t, regexp.MustCompile(`^\S+ /grpc.testing.TestService/UnaryCall application/grpc-web-text text_request=true text_response=true bytes=28 grpc_status=NotFound duration=\S+\n$`), buf.String())
+}
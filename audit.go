@@ -0,0 +1,117 @@
+package grpcweb
+
+import (
+	"io"
+	"strings"
+)
+
+// defaultAuditMaxBytes is the default limit on the bytes captured in each
+// direction of an audited request.
+const defaultAuditMaxBytes = 64 << 10
+
+// AuditRecord is a copy of the messages exchanged by an audited request.
+//
+// Request and Response contain the length-prefixed gRPC messages, as read by
+// and written by the gRPC handler (after any base64 decoding). Each is
+// truncated at the configured limit, in which case the respective Truncated
+// field is set.
+type AuditRecord struct {
+	Method string
+
+	Request          []byte
+	RequestTruncated bool
+
+	Response          []byte
+	ResponseTruncated bool
+}
+
+// AuditConfig configures the requests captured for an audit hook.
+type AuditConfig struct {
+	// Methods are the full method names ("/package.Service/Method") to audit.
+	// If empty, every method is audited.
+	Methods []string
+
+	// MaxBytes limits the bytes captured for each of the request and
+	// response. If zero, 64KiB is used.
+	MaxBytes int
+
+	// Redact, if set, is called with each record before it is passed to the
+	// audit hook, and returns the record with any sensitive data removed.
+	Redact func(AuditRecord) AuditRecord
+}
+
+type auditor struct {
+	hook   func(AuditRecord)
+	config AuditConfig
+}
+
+func (a *auditor) audits(method string) bool {
+	if a == nil || a.hook == nil {
+		return false
+	}
+	if len(a.config.Methods) == 0 {
+		return true
+	}
+
+	for _, m := range a.config.Methods {
+		if strings.TrimPrefix(m, "/") == strings.TrimPrefix(method, "/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *auditor) maxBytes() int {
+	if a.config.MaxBytes > 0 {
+		return a.config.MaxBytes
+	}
+
+	return defaultAuditMaxBytes
+}
+
+func (a *auditor) record(method string, request, response *auditBuffer) {
+	record := AuditRecord{
+		Method:            method,
+		Request:           request.data,
+		RequestTruncated:  request.truncated,
+		Response:          response.data,
+		ResponseTruncated: response.truncated,
+	}
+
+	if a.config.Redact != nil {
+		record = a.config.Redact(record)
+	}
+
+	a.hook(record)
+}
+
+// auditBuffer captures bytes written to it up to a limit, so that the stream
+// being captured is never held in memory in its entirety.
+type auditBuffer struct {
+	data      []byte
+	max       int
+	truncated bool
+}
+
+func (b *auditBuffer) capture(p []byte) {
+	if remaining := b.max - len(b.data); len(p) > remaining {
+		p = p[:remaining]
+		b.truncated = true
+	}
+
+	b.data = append(b.data, p...)
+}
+
+// auditBody captures the request body as it is read by the handler.
+type auditBody struct {
+	io.ReadCloser
+	buf *auditBuffer
+}
+
+func (b auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.capture(p[:n])
+
+	return n, err
+}
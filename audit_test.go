@@ -0,0 +1,97 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestAuditHook(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	var records []grpcweb.AuditRecord
+	ts := httptest.NewServer(grpcweb.Handler(server,
+		grpcweb.WithAuditHook(func(record grpcweb.AuditRecord) {
+			records = append(records, record)
+		}),
+		grpcweb.WithAuditConfig(grpcweb.AuditConfig{
+			Methods:  []string{"/grpc.testing.TestService/UnaryCall", "/grpc.testing.TestService/StreamingOutputCall"},
+			MaxBytes: 16,
+			Redact: func(record grpcweb.AuditRecord) grpcweb.AuditRecord {
+				record.Method += " (redacted)"
+				return record
+			},
+		}),
+	))
+	defer ts.Close()
+
+	for path, body := range map[string]string{
+		"/grpc.testing.TestService/EmptyCall":           "AAAAAAA=",
+		"/grpc.testing.TestService/UnaryCall":           "AAAAAAQQBSAB",
+		"/grpc.testing.TestService/StreamingOutputCall": "AAAAAAgSAggFEgIICg==",
+	} {
+		records = nil
+
+		req, err := http.NewRequest("POST", ts.URL+path, bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		switch path {
+		case "/grpc.testing.TestService/EmptyCall":
+			assert.Empty(t, records)
+
+		case "/grpc.testing.TestService/UnaryCall":
+			assert.Equal(t, []grpcweb.AuditRecord{{
+				Method:   path + " (redacted)",
+				Request:  []byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05, 0x20, 0x01},
+				Response: []byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00},
+			}}, records)
+
+		case "/grpc.testing.TestService/StreamingOutputCall":
+			assert.Equal(t, []grpcweb.AuditRecord{{
+				Method:            path + " (redacted)",
+				Request:           []byte{0x00, 0x00, 0x00, 0x00, 0x08, 0x12, 0x02, 0x08, 0x05, 0x12, 0x02, 0x08, 0x0a},
+				Response:          []byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+				ResponseTruncated: true,
+			}}, records)
+		}
+	}
+}
+
+func TestAuditResponseLimit(t *testing.T) {
+	var records []grpcweb.AuditRecord
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		for _, p := range [][]byte{{0x00, 0x00}, {0x00, 0x00, 0x02, 0x01}, {0x01}, {0x00, 0x00}, {0x00, 0x00, 0x01, 0x01}} {
+			resp.Write(p)
+		}
+	}), grpcweb.WithMaxResponseBytes(12), grpcweb.WithAuditHook(func(record grpcweb.AuditRecord) {
+		records = append(records, record)
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// the frame refused for exceeding the limit was never sent, and so isn't
+	// audited
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01}, records[0].Response)
+		assert.False(t, records[0].ResponseTruncated)
+	}
+}
@@ -0,0 +1,93 @@
+package grpcweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ClientConfig describes how browser clients can reach a gRPC-Web handler, as
+// served by the endpoint added by WithClientConfigEndpoint.
+type ClientConfig struct {
+	// BaseURL is the URL that clients should send requests to, if any.
+	BaseURL string `json:"baseUrl,omitempty"`
+
+	// ContentTypes are the gRPC-Web content-types requests can be sent with.
+	ContentTypes []string `json:"contentTypes"`
+
+	// ContinuousText is true if text responses are one continuous base64
+	// stream, rather than a segment per flush.
+	ContinuousText bool `json:"continuousText"`
+
+	// RequireGrpcWebHeader is true if requests must have an X-Grpc-Web
+	// header.
+	RequireGrpcWebHeader bool `json:"requireGrpcWebHeader"`
+
+	// SSE is true if responses can be delivered as Server-Sent Events.
+	SSE bool `json:"sse"`
+
+	// CORS is set if cross-origin requests are allowed.
+	CORS *ClientCORSConfig `json:"cors,omitempty"`
+}
+
+// ClientCORSConfig describes the cross-origin requests allowed by WithCORS.
+type ClientCORSConfig struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedHeaders []string `json:"allowedHeaders"`
+	ExposedHeaders []string `json:"exposedHeaders"`
+}
+
+// clientConfig serves the ClientConfig of a handler.
+type clientConfig struct {
+	path   string
+	config ClientConfig
+}
+
+// WithClientConfigEndpoint serves the handler's ClientConfig as JSON at the
+// path provided, so that browser clients can fetch it at startup rather than
+// hard-coding it. baseURL is served as the URL clients should send requests
+// to, and may be empty. Like WithMetricsEndpoint, only GET and HEAD requests
+// that aren't gRPC or gRPC-Web requests are served by the endpoint.
+func WithClientConfigEndpoint(path, baseURL string) Option {
+	return func(o *options) {
+		o.clientConfig = &clientConfig{path: path, config: ClientConfig{BaseURL: baseURL}}
+	}
+}
+
+// describe completes the config from the handler's options, once they've all
+// been applied.
+func (c *clientConfig) describe(o *options) {
+	c.config.ContentTypes = []string{
+		ContentTypeGRPCWeb,
+		ContentTypeGRPCWebProto,
+		ContentTypeGRPCWebJSON,
+		ContentTypeGRPCWebText,
+		ContentTypeGRPCWebTextProto,
+		ContentTypeGRPCWebTextJSON,
+	}
+	c.config.ContinuousText = o.continuousText
+	c.config.RequireGrpcWebHeader = o.requireGRPCWebHeader
+	c.config.SSE = o.sse
+
+	if o.cors != nil {
+		origins := o.cors.config.AllowedOrigins
+		if len(origins) == 0 {
+			origins = []string{"*"}
+		}
+
+		c.config.CORS = &ClientCORSConfig{
+			AllowedOrigins: origins,
+			AllowedHeaders: strings.Split(o.cors.allowedHeaders, ", "),
+			ExposedHeaders: strings.Split(o.cors.exposedHeaders, ", "),
+		}
+	}
+}
+
+func (c *clientConfig) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set(headerContentType, "application/json")
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	json.NewEncoder(resp).Encode(c.config)
+}
@@ -0,0 +1,54 @@
+package grpcweb_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientConfigEndpoint(t *testing.T) {
+	handler := grpcweb.Handler(http.NotFoundHandler(),
+		grpcweb.WithClientConfigEndpoint("/grpcweb.json", "https://api.example.com"),
+		grpcweb.WithCORS(grpcweb.CORSConfig{AllowedHeaders: []string{"authorization"}}),
+		grpcweb.WithRequireGrpcWebHeader(),
+	)
+
+	req := httptest.NewRequest("GET", "/grpcweb.json", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("content-type"))
+
+	var config grpcweb.ClientConfig
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &config))
+	assert.Equal(t, grpcweb.ClientConfig{
+		BaseURL: "https://api.example.com",
+		ContentTypes: []string{
+			grpcweb.ContentTypeGRPCWeb,
+			grpcweb.ContentTypeGRPCWebProto,
+			grpcweb.ContentTypeGRPCWebJSON,
+			grpcweb.ContentTypeGRPCWebText,
+			grpcweb.ContentTypeGRPCWebTextProto,
+			grpcweb.ContentTypeGRPCWebTextJSON,
+		},
+		RequireGrpcWebHeader: true,
+		CORS: &grpcweb.ClientCORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedHeaders: []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "grpc-encoding", "authorization"},
+			ExposedHeaders: []string{"grpc-status", "grpc-message", "grpc-status-details-bin", "grpc-encoding"},
+		},
+	}, config)
+
+	// gRPC-Web requests for the path aren't served by the endpoint
+	req = httptest.NewRequest("GET", "/grpcweb.json", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("x-grpc-web", "1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.NotEqual(t, "application/json", rec.Header().Get("content-type"))
+}
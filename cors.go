@@ -0,0 +1,156 @@
+package grpcweb
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSAllowedHeaders are the request headers sent by gRPC-Web clients.
+var defaultCORSAllowedHeaders = []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout", "grpc-encoding"}
+
+// defaultCORSExposedHeaders are the response headers read by gRPC-Web clients.
+var defaultCORSExposedHeaders = []string{"grpc-status", "grpc-message", "grpc-status-details-bin", "grpc-encoding"}
+
+// CORSConfig configures the cross-origin requests allowed by WithCORS.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to make requests. If empty, any
+	// origin is allowed.
+	AllowedOrigins []string
+
+	// AllowedHeaders are the request headers allowed in addition to those
+	// sent by gRPC-Web clients, such as authorization.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers exposed to the client in
+	// addition to the gRPC status headers. Headers can also be exposed with
+	// WithExposedHeaders.
+	ExposedHeaders []string
+
+	// MaxAge is how long the response to a preflight request can be cached
+	// for. If zero, the client's default is used. It's rounded up to whole
+	// seconds.
+	MaxAge time.Duration
+}
+
+type cors struct {
+	config         CORSConfig
+	allowedHeaders string
+	exposedHeaders string
+}
+
+// WithCORS allows gRPC-Web requests from the origins configured, and responds
+// to their preflight requests, so that browsers can make requests to a
+// different origin.
+func WithCORS(config CORSConfig) Option {
+	return func(o *options) {
+		o.cors = &cors{
+			config:         config,
+			allowedHeaders: strings.Join(append(append([]string{}, defaultCORSAllowedHeaders...), config.AllowedHeaders...), ", "),
+			exposedHeaders: strings.Join(append(append([]string{}, defaultCORSExposedHeaders...), config.ExposedHeaders...), ", "),
+		}
+	}
+}
+
+// WithPreflightHandler serves OPTIONS requests that aren't gRPC or gRPC-Web
+// requests using handler, rather than the fallback or wrapped handler, so
+// that preflight requests can be answered by existing CORS middleware. It
+// takes precedence over WithCORS.
+func WithPreflightHandler(handler http.Handler) Option {
+	return func(o *options) {
+		o.preflightHandler = handler
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for the origin,
+// if the origin is allowed.
+func (c *cors) allowOrigin(origin string) (string, bool) {
+	if len(c.config.AllowedOrigins) == 0 {
+		return "*", true
+	}
+
+	for _, allowed := range c.config.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
+
+// WithExposedHeaders exposes the response headers provided, such as custom
+// leading metadata, to browser clients, in addition to the gRPC status headers
+// and CORSConfig.ExposedHeaders. It has no effect without WithCORS, as
+// same-origin clients can read every response header.
+func WithExposedHeaders(headers []string) Option {
+	return func(o *options) {
+		o.exposedHeaders = append(o.exposedHeaders, headers...)
+	}
+}
+
+// expose adds headers to the Access-Control-Expose-Headers value, skipping
+// those already exposed.
+func (c *cors) expose(headers []string) {
+	exposed := strings.Split(c.exposedHeaders, ", ")
+	for _, header := range headers {
+		header = strings.ToLower(strings.TrimSpace(header))
+
+		found := header == ""
+		for _, name := range exposed {
+			found = found || strings.EqualFold(name, header)
+		}
+		if !found {
+			exposed = append(exposed, header)
+		}
+	}
+
+	c.exposedHeaders = strings.Join(exposed, ", ")
+}
+
+// isPreflight returns whether the request is a CORS preflight request.
+func (c *cors) isPreflight(req *http.Request) bool {
+	return c != nil &&
+		req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *cors) servePreflight(resp http.ResponseWriter, req *http.Request) {
+	header := resp.Header()
+	header.Add("Vary", "Origin")
+
+	origin, ok := c.allowOrigin(req.Header.Get("Origin"))
+	if !ok {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header.Set("Access-Control-Allow-Origin", origin)
+	header.Set("Access-Control-Allow-Methods", http.MethodPost)
+	header.Set("Access-Control-Allow-Headers", c.allowedHeaders)
+	if c.config.MaxAge > 0 {
+		// the header is in whole seconds, so a fraction of one is rounded up
+		// rather than down to zero, which would disable caching
+		secs := c.config.MaxAge / time.Second
+		if c.config.MaxAge%time.Second != 0 {
+			secs++
+		}
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(secs), 10))
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// setHeaders sets the CORS headers of a response to a gRPC-Web request.
+func (c *cors) setHeaders(header http.Header, req *http.Request) {
+	if c == nil || req.Header.Get("Origin") == "" {
+		return
+	}
+
+	header.Add("Vary", "Origin")
+	if origin, ok := c.allowOrigin(req.Header.Get("Origin")); ok {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
@@ -0,0 +1,146 @@
+package grpcweb_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	fallback := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusTeapot)
+	})
+	handler := grpcweb.RootHandler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}), fallback,
+		grpcweb.WithCORS(grpcweb.CORSConfig{
+			AllowedOrigins: []string{"https://example.com"},
+			AllowedHeaders: []string{"authorization", "x-tenant-id"},
+			ExposedHeaders: []string{"x-request-id"},
+			MaxAge:         10 * time.Minute,
+		}),
+	)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("origin", origin)
+		req.Header.Set("access-control-request-method", "POST")
+		req.Header.Set("access-control-request-headers", "content-type, x-grpc-web, authorization")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://example.com")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://example.com", rec.Header().Get("access-control-allow-origin"))
+	assert.Equal(t, "POST", rec.Header().Get("access-control-allow-methods"))
+	assert.Equal(t, "content-type, x-grpc-web, x-user-agent, grpc-timeout, grpc-encoding, authorization, x-tenant-id", rec.Header().Get("access-control-allow-headers"))
+	assert.Equal(t, "600", rec.Header().Get("access-control-max-age"))
+	assert.Equal(t, "Origin", rec.Header().Get("vary"))
+
+	rec = preflight("https://other.com")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("access-control-allow-origin"))
+
+	// OPTIONS requests that aren't preflight requests are left to the fallback
+	req := httptest.NewRequest("OPTIONS", "/", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+
+	// gRPC-Web responses expose the gRPC status headers
+	req = httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("origin", "https://example.com")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, "https://example.com", rec.Header().Get("access-control-allow-origin"))
+	assert.Equal(t, "grpc-status, grpc-message, grpc-status-details-bin, grpc-encoding, x-request-id", rec.Header().Get("access-control-expose-headers"))
+}
+
+func TestCORSDefaults(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}), grpcweb.WithCORS(grpcweb.CORSConfig{}))
+
+	req := httptest.NewRequest("OPTIONS", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("origin", "https://example.com")
+	req.Header.Set("access-control-request-method", "POST")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("access-control-allow-origin"))
+	assert.Equal(t, "content-type, x-grpc-web, x-user-agent, grpc-timeout, grpc-encoding", rec.Header().Get("access-control-allow-headers"))
+	assert.Empty(t, rec.Header().Get("access-control-max-age"))
+}
+
+func TestCORSMaxAge(t *testing.T) {
+	for maxAge, expected := range map[time.Duration]string{
+		time.Minute:             "60",
+		500 * time.Millisecond:  "1",
+		1500 * time.Millisecond: "2",
+		time.Nanosecond:         "1",
+	} {
+		handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}), grpcweb.WithCORS(grpcweb.CORSConfig{MaxAge: maxAge}))
+
+		req := httptest.NewRequest("OPTIONS", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("origin", "https://example.com")
+		req.Header.Set("access-control-request-method", "POST")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Header().Get("access-control-max-age"), maxAge)
+	}
+}
+
+func TestExposedHeaders(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("x-request-id", "1")
+	}),
+		grpcweb.WithExposedHeaders([]string{"X-Request-Id", "grpc-status"}),
+		grpcweb.WithCORS(grpcweb.CORSConfig{ExposedHeaders: []string{"x-tenant-id"}}),
+		grpcweb.WithExposedHeaders([]string{"x-trace-bin"}),
+	)
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("origin", "https://example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// headers already exposed aren't repeated, whichever order the options are in
+	assert.Equal(t, "1", rec.Header().Get("x-request-id"))
+	assert.Equal(t, "grpc-status, grpc-message, grpc-status-details-bin, grpc-encoding, x-tenant-id, x-request-id, x-trace-bin", rec.Header().Get("access-control-expose-headers"))
+}
+
+func TestPreflightHandler(t *testing.T) {
+	served := func(name string) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("served-by", name)
+		})
+	}
+	handler := grpcweb.RootHandler(served("grpc"), served("fallback"),
+		grpcweb.WithPreflightHandler(served("preflight")),
+		grpcweb.WithCORS(grpcweb.CORSConfig{}),
+	)
+
+	req := httptest.NewRequest("OPTIONS", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("origin", "https://example.com")
+	req.Header.Set("access-control-request-method", "POST")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "preflight", rec.Header().Get("served-by"))
+	assert.Empty(t, rec.Header().Get("access-control-allow-origin"))
+
+	// other requests are dispatched as usual
+	req = httptest.NewRequest("GET", "/", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "fallback", rec.Header().Get("served-by"))
+}
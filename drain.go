@@ -0,0 +1,31 @@
+package grpcweb
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// DrainableHandler is a gRPC-Web handler that can be drained ahead of the
+// server shutting down.
+type DrainableHandler struct {
+	h *grpcWebHandler
+}
+
+// NewDrainableHandler returns a DrainableHandler that bridges gRPC-Web
+// clients to the provided gRPC handler, as Handler does.
+func NewDrainableHandler(h http.Handler, opts ...Option) *DrainableHandler {
+	return &DrainableHandler{&grpcWebHandler{handler: h, opts: newOptions(opts)}}
+}
+
+func (d *DrainableHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	d.h.ServeHTTP(resp, req)
+}
+
+// Drain rejects new gRPC-Web requests with an UNAVAILABLE status, while
+// letting those in progress complete. Call it before shutting the server
+// down with http.Server's Shutdown, which waits for them. Native gRPC
+// requests aren't rejected, and should be drained with the gRPC server's
+// GracefulStop.
+func (d *DrainableHandler) Drain() {
+	atomic.StoreInt32(&d.h.draining, 1)
+}
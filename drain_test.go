@@ -0,0 +1,48 @@
+package grpcweb_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := grpcweb.NewDrainableHandler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+
+		resp.Header().Set("Trailer", "Grpc-Status")
+		resp.Header().Set("Grpc-Status", "0")
+	}))
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		return req
+	}
+
+	inProgress := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(inProgress, newRequest())
+	}()
+	<-started
+
+	handler.Drain()
+
+	// new requests are rejected
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest())
+	assert.Equal(t, trailerFrame("grpc-message: server is shutting down\r\ngrpc-status: 14\r\n"), rec.Body.Bytes())
+
+	// while those in progress complete
+	close(release)
+	<-done
+	assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), inProgress.Body.Bytes())
+}
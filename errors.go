@@ -0,0 +1,80 @@
+package grpcweb
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Errors responded to with a status by the handler itself, rather than by the
+// wrapped handler.
+var (
+	ErrInvalidBase64       = errors.New("invalid base64 request body")
+	ErrInvalidGzip         = errors.New("invalid gzip compressed request message")
+	ErrTruncatedFrame      = errors.New("truncated request message frame")
+	ErrCompressedTooBig    = errors.New("compressed request message too large")
+	ErrFrameTooLarge       = errors.New("request message frame exceeds the maximum frame size")
+	ErrNoEncoding          = errors.New("compressed request message without a grpc-encoding")
+	ErrUnsupportedEncoding = errors.New("unsupported grpc-encoding")
+	ErrResponseTooLarge    = errors.New("response message exceeds the maximum response size")
+	ErrTooManyRequests     = errors.New("too many concurrent requests")
+	ErrDraining            = errors.New("server is shutting down")
+	ErrNotFlushable        = errors.New("streamed response can't be flushed")
+)
+
+// isDecodeError returns whether err is the result of a request body that
+// couldn't be decoded.
+func isDecodeError(err error) bool {
+	switch err {
+	case ErrInvalidBase64, ErrInvalidGzip, ErrTruncatedFrame, ErrCompressedTooBig, ErrFrameTooLarge, ErrNoEncoding:
+		return true
+	}
+
+	return false
+}
+
+// defaultErrorCode returns the status code the handler responds with for an
+// error, following gRPC's conventions.
+func defaultErrorCode(err error) codes.Code {
+	switch err {
+	case ErrNoEncoding, ErrUnsupportedEncoding:
+		return codes.Unimplemented
+	case ErrCompressedTooBig, ErrResponseTooLarge, ErrTooManyRequests:
+		return codes.ResourceExhausted
+	case context.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case ErrDraining:
+		return codes.Unavailable
+	case ErrNotFlushable:
+		return codes.Internal
+	}
+
+	return codes.InvalidArgument
+}
+
+// WithErrorMapper overrides the status code responded with when a request
+// fails in the handler itself, rather than in the wrapped handler. fn is
+// called with one of the errors of this package, or context.DeadlineExceeded
+// for requests that run past their deadline without the wrapped handler
+// responding with a status.
+//
+// By default, request bodies that can't be decoded are responded to with
+// INVALID_ARGUMENT, exceeded limits with RESOURCE_EXHAUSTED, compressed
+// messages without an encoding, or with an unsupported one, with
+// UNIMPLEMENTED, requests past their deadline with DEADLINE_EXCEEDED, requests
+// to a draining handler with UNAVAILABLE, and streamed responses that can't be
+// flushed with INTERNAL.
+func WithErrorMapper(fn func(error) codes.Code) Option {
+	return func(o *options) {
+		o.errorMapper = fn
+	}
+}
+
+func (o *options) errorCode(err error) codes.Code {
+	if o.errorMapper != nil {
+		return o.errorMapper(err)
+	}
+
+	return defaultErrorCode(err)
+}
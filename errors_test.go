@@ -0,0 +1,89 @@
+package grpcweb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestErrorMapper(t *testing.T) {
+	var errs []error
+	mapper := grpcweb.WithErrorMapper(func(err error) codes.Code {
+		errs = append(errs, err)
+		return codes.FailedPrecondition
+	})
+
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done()
+	})
+
+	for name, test := range map[string]struct {
+		body     string
+		encoding string
+		opts     []grpcweb.Option
+		err      error
+		expected []byte
+	}{
+		"default invalid base64": {
+			"!",
+			"",
+			nil,
+			nil,
+			trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"),
+		},
+		"default deadline exceeded": {
+			"",
+			"",
+			nil,
+			nil,
+			trailerFrame("grpc-message: deadline exceeded\r\ngrpc-status: 4\r\n"),
+		},
+		"invalid base64": {
+			"!",
+			"",
+			[]grpcweb.Option{mapper},
+			grpcweb.ErrInvalidBase64,
+			trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 9\r\n"),
+		},
+		"deadline exceeded": {
+			"",
+			"",
+			[]grpcweb.Option{mapper},
+			context.DeadlineExceeded,
+			trailerFrame("grpc-message: deadline exceeded\r\ngrpc-status: 9\r\n"),
+		},
+		"unsupported encoding": {
+			"",
+			"br",
+			[]grpcweb.Option{mapper},
+			grpcweb.ErrUnsupportedEncoding,
+			trailerFrame("grpc-message: grpc: Decompressor is not installed for grpc-encoding \"br\"\r\ngrpc-status: 9\r\n"),
+		},
+	} {
+		errs = nil
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader(test.body))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		req.Header.Set("accept", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("grpc-timeout", "10m")
+		if test.encoding != "" {
+			req.Header.Set("grpc-encoding", test.encoding)
+		}
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, test.opts...).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+		if test.err != nil {
+			assert.Equal(t, []error{test.err}, errs, name)
+		}
+	}
+}
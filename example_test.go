@@ -0,0 +1,44 @@
+package grpcweb_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/saracen/grpcweb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func ExampleHandler() {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	// an EmptyCall request, a single uncompressed frame with an empty
+	// message, base64 encoded
+	body := base64.StdEncoding.EncodeToString([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+
+	req, _ := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", strings.NewReader(body))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	fmt.Println(resp.Header.Get("content-type"))
+	fmt.Println(string(data))
+	// Output:
+	// application/grpc-web-text
+	// AAAAAAA=gAAAABBncnBjLXN0YXR1czogMA0K
+}
@@ -0,0 +1,57 @@
+package grpcweb
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// WithForwardedClientIP passes requests to the gRPC handler with the address
+// of the client that a trusted proxy in front of the handler forwarded them
+// for, rather than the address of the proxy, so that gRPC handlers see it
+// with peer.FromContext. It applies to gRPC-Web requests, and native gRPC
+// requests served by RootHandler.
+//
+// hops is the number of trusted proxies that each append the address they
+// received the request from to the X-Forwarded-For header, and so the client's
+// address is the hops'th from the end of it. Addresses further from the end
+// can be set by the client, and so aren't trusted. Without X-Forwarded-For, an
+// X-Real-IP header is trusted instead, as set by a single proxy. The port of
+// the client's address is reported as 0, as it isn't forwarded.
+func WithForwardedClientIP(hops int) Option {
+	return func(o *options) {
+		o.forwardedHops = hops
+	}
+}
+
+// forwardClientIP returns the request with the forwarded client's address as
+// its RemoteAddr, if one is trusted.
+func (o *options) forwardClientIP(req *http.Request) *http.Request {
+	if o.forwardedHops <= 0 {
+		return req
+	}
+
+	var forwarded []string
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+
+	var addr string
+	switch {
+	case len(forwarded) >= o.forwardedHops:
+		addr = forwarded[len(forwarded)-o.forwardedHops]
+	case len(forwarded) == 0:
+		addr = strings.TrimSpace(req.Header.Get("X-Real-IP"))
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	req.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+	return req
+}
@@ -0,0 +1,52 @@
+package grpcweb_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/peer"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	var addr string
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		p, ok := peer.FromContext(ctx)
+		assert.True(t, ok)
+		addr = p.Addr.String()
+
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for name, test := range map[string]struct {
+		hops     int
+		header   map[string]string
+		expected string
+	}{
+		"disabled":          {0, map[string]string{"x-forwarded-for": "1.2.3.4"}, "192.0.2.1:1234"},
+		"one proxy":         {1, map[string]string{"x-forwarded-for": "1.2.3.4, 10.0.0.1"}, "10.0.0.1:0"},
+		"two proxies":       {2, map[string]string{"x-forwarded-for": "1.2.3.4, 10.0.0.1"}, "1.2.3.4:0"},
+		"too few addresses": {3, map[string]string{"x-forwarded-for": "1.2.3.4, 10.0.0.1"}, "192.0.2.1:1234"},
+		"real ip":           {1, map[string]string{"x-real-ip": "2001:db8::1"}, "[2001:db8::1]:0"},
+		"invalid":           {1, map[string]string{"x-forwarded-for": "unknown"}, "192.0.2.1:1234"},
+	} {
+		addr = ""
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAA="))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		for key, value := range test.header {
+			req.Header.Set(key, value)
+		}
+
+		grpcweb.Handler(server, grpcweb.WithForwardedClientIP(test.hops)).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, test.expected, addr, name)
+	}
+}
@@ -0,0 +1,244 @@
+package grpcweb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// maxDecompressedMessage is the largest message decompressed, matching the
+// size of the largest message a gRPC server receives by default.
+const maxDecompressedMessage = 4 << 20
+
+// frameReader validates a stream of frames, reporting frames that end before
+// their declared length as ErrTruncatedFrame. If decompress is true, gzip
+// compressed messages are rewritten as uncompressed frames, for handlers that
+// may not support the client's encoding. Otherwise, no encoding was given, so
+// compressed messages are reported as ErrNoEncoding. If max is positive,
+// frames with longer messages are reported as ErrFrameTooLarge, before they're
+// read.
+type frameReader struct {
+	r          io.Reader
+	decompress bool
+	max        int64
+
+	// buf holds a frame header or decompressed frame yet to be read, and
+	// remaining is the length of the current frame's payload yet to be read
+	// from r.
+	buf       bytes.Buffer
+	remaining int64
+	err       error
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for {
+		if f.buf.Len() > 0 {
+			return f.buf.Read(p)
+		}
+
+		if f.remaining > 0 {
+			if int64(len(p)) > f.remaining {
+				p = p[:f.remaining]
+			}
+
+			n, err := f.r.Read(p)
+			f.remaining -= int64(n)
+			if err == io.EOF {
+				err = nil
+				if f.remaining > 0 {
+					err = ErrTruncatedFrame
+				}
+			}
+
+			return n, err
+		}
+
+		if f.err != nil {
+			return 0, f.err
+		}
+		f.err = f.next()
+	}
+}
+
+// next reads the header of the next frame, and if it's to be decompressed,
+// the frame itself.
+func (f *frameReader) next() error {
+	var header [FrameHeaderLength]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncatedFrame
+		}
+		return err
+	}
+
+	length := int64(binary.BigEndian.Uint32(header[1:]))
+	if f.max > 0 && length > f.max {
+		return ErrFrameTooLarge
+	}
+	if header[0]&CompressedFrameFlag != 0 && !f.decompress {
+		return ErrNoEncoding
+	}
+	if header[0]&CompressedFrameFlag == 0 {
+		f.buf.Write(header[:])
+		f.remaining = length
+		return nil
+	}
+
+	if length > maxDecompressedMessage {
+		return ErrCompressedTooBig
+	}
+
+	compressed, err := ioutil.ReadAll(io.LimitReader(f.r, length))
+	if err != nil {
+		return err
+	}
+	if int64(len(compressed)) < length {
+		return ErrTruncatedFrame
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return ErrInvalidGzip
+	}
+
+	msg, err := ioutil.ReadAll(io.LimitReader(zr, maxDecompressedMessage+1))
+	if err != nil || len(msg) > maxDecompressedMessage {
+		return ErrInvalidGzip
+	}
+	if f.max > 0 && int64(len(msg)) > f.max {
+		return ErrFrameTooLarge
+	}
+
+	header[0] &^= CompressedFrameFlag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+	f.buf.Write(header[:])
+	f.buf.Write(msg)
+
+	return nil
+}
+
+// frameLimit limits the size of the frames written to a response. Frames are
+// either accepted or refused whole, so that a response is never left ending
+// partway through a message.
+type frameLimit struct {
+	max     int64
+	written int64
+
+	// remaining is the length of the accepted frame's payload yet to be
+	// written, and header holds a frame header written in part.
+	remaining int64
+	header    []byte
+}
+
+// accept returns the part of p to be written, and false if a frame has been
+// refused. A frame header that's incomplete is held back until the rest of it
+// is written.
+func (l *frameLimit) accept(p []byte) ([]byte, bool) {
+	out := p
+	if len(l.header) > 0 {
+		out = append(append([]byte(nil), l.header...), p...)
+		l.header = l.header[:0]
+	}
+
+	for i := 0; i < len(out); {
+		if l.remaining > 0 {
+			n := int64(len(out) - i)
+			if n > l.remaining {
+				n = l.remaining
+			}
+			l.remaining -= n
+			i += int(n)
+			continue
+		}
+
+		if len(out)-i < FrameHeaderLength {
+			l.header = append(l.header, out[i:]...)
+			return out[:i], true
+		}
+
+		length := int64(binary.BigEndian.Uint32(out[i+1 : i+FrameHeaderLength]))
+		if l.written+FrameHeaderLength+length > l.max {
+			return out[:i], false
+		}
+
+		l.written += FrameHeaderLength + length
+		l.remaining = length
+		i += FrameHeaderLength
+	}
+
+	return out, true
+}
+
+// fits returns whether a frame of n bytes can be written without exceeding
+// the limit, or splitting a frame that's been written in part.
+func (l *frameLimit) fits(n int64) bool {
+	return l.remaining == 0 && len(l.header) == 0 && l.written+n <= l.max
+}
+
+// base64Reader decodes a base64 request body, which a client may have encoded
+// as several independently padded segments, such as one per message. Any
+// failure to decode base64 is reported as ErrInvalidBase64, including input
+// that ends partway through a group.
+type base64Reader struct {
+	r io.Reader
+
+	// in holds input yet to be decoded, and out decoded data yet to be read.
+	in  []byte
+	out []byte
+	buf [4096]byte
+	err error
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		n, err := r.r.Read(r.buf[:])
+		for _, c := range r.buf[:n] {
+			if c != '\r' && c != '\n' {
+				r.in = append(r.in, c)
+			}
+		}
+		r.decode()
+
+		switch {
+		case r.err != nil:
+		case err == io.EOF && len(r.in) > 0:
+			r.err = ErrInvalidBase64
+		default:
+			r.err = err
+		}
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+
+	return n, nil
+}
+
+// decode decodes each complete group of the input, ending a segment at every
+// group that is padded.
+func (r *base64Reader) decode() {
+	r.out = r.out[:0]
+	for len(r.in) >= 4 {
+		end := len(r.in) / 4 * 4
+		if i := bytes.IndexByte(r.in[:end], '='); i >= 0 {
+			end = i/4*4 + 4
+		}
+
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(end))
+		n, err := base64.StdEncoding.Decode(decoded, r.in[:end])
+		if err != nil {
+			r.err = ErrInvalidBase64
+			return
+		}
+
+		r.out = append(r.out, decoded[:n]...)
+		r.in = r.in[end:]
+	}
+}
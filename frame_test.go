@@ -0,0 +1,385 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+// compressedFrame returns a message frame with the gzip compressed message.
+func compressedFrame(t *testing.T, msg []byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write(msg)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	header := make([]byte, grpcweb.FrameHeaderLength)
+	header[0] = grpcweb.CompressedFrameFlag
+	binary.BigEndian.PutUint32(header[1:], uint32(buf.Len()))
+
+	return append(header, buf.Bytes()...)
+}
+
+func TestCompressedRequest(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	// a SimpleRequest with a response size of 5 and a payload of 1
+	unary := compressedFrame(t, []byte{0x10, 0x05, 0x20, 0x01})
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+		expected    []byte
+	}{
+		{
+			"binary",
+			grpcweb.ContentTypeGRPCWeb,
+			"gzip",
+			unary,
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+		{
+			"text",
+			grpcweb.ContentTypeGRPCWebText,
+			"gzip",
+			[]byte(base64.StdEncoding.EncodeToString(unary)),
+			[]byte("AAAAAAkKBxIFAAAAAAA=gAAAABBncnBjLXN0YXR1czogMA0K"),
+		},
+		{
+			"uncompressed frame",
+			grpcweb.ContentTypeGRPCWeb,
+			"gzip",
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05, 0x20, 0x01},
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+		{
+			"invalid gzip",
+			grpcweb.ContentTypeGRPCWeb,
+			"gzip",
+			[]byte{grpcweb.CompressedFrameFlag, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05, 0x20, 0x01},
+			trailerFrame("grpc-message: invalid gzip compressed request message\r\ngrpc-status: 3\r\n"),
+		},
+		{
+			"compressed without an encoding",
+			grpcweb.ContentTypeGRPCWeb,
+			"",
+			unary,
+			trailerFrame("grpc-message: compressed request message without a grpc-encoding\r\ngrpc-status: 12\r\n"),
+		},
+		{
+			"text compressed without an encoding",
+			grpcweb.ContentTypeGRPCWebText,
+			"identity",
+			[]byte(base64.StdEncoding.EncodeToString(unary)),
+			[]byte(base64.StdEncoding.EncodeToString(trailerFrame("grpc-message: compressed request message without a grpc-encoding\r\ngrpc-status: 12\r\n"))),
+		},
+		{
+			"unsupported encoding",
+			grpcweb.ContentTypeGRPCWeb,
+			"br",
+			unary,
+			trailerFrame("grpc-message: grpc: Decompressor is not installed for grpc-encoding \"br\"\r\ngrpc-status: 12\r\n"),
+		},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/UnaryCall", bytes.NewReader(test.body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", test.contentType)
+		req.Header.Set("grpc-encoding", test.encoding)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, test.name)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, test.expected, data, test.name)
+	}
+}
+
+func TestTruncatedFrame(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	truncated := trailerFrame("grpc-message: truncated request message frame\r\ngrpc-status: 3\r\n")
+	for _, test := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{grpcweb.ContentTypeGRPCWeb, []byte{0x00, 0x00, 0x00}},
+		{grpcweb.ContentTypeGRPCWeb, []byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05}},
+		{grpcweb.ContentTypeGRPCWebText, []byte(base64.StdEncoding.EncodeToString([]byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05}))},
+	} {
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/UnaryCall", bytes.NewReader(test.body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", test.contentType)
+		req.Header.Set("accept", grpcweb.ContentTypeGRPCWeb)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, truncated, data, test.body)
+	}
+}
+
+func TestSegmentedTextRequest(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	// StreamingInputCallRequests with payloads of 1, 2 and 3 bytes, each
+	// encoded as its own padded segment
+	var body string
+	for _, frame := range [][]byte{
+		{0x00, 0x00, 0x00, 0x00, 0x05, 0x0a, 0x03, 0x12, 0x01, 0x01},
+		{0x00, 0x00, 0x00, 0x00, 0x06, 0x0a, 0x04, 0x12, 0x02, 0x02, 0x02},
+		{0x00, 0x00, 0x00, 0x00, 0x07, 0x0a, 0x05, 0x12, 0x03, 0x03, 0x03, 0x03},
+	} {
+		body += base64.StdEncoding.EncodeToString(frame)
+	}
+
+	for name, test := range map[string]struct {
+		body     string
+		expected []byte
+	}{
+		"segmented": {
+			body,
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x06}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+		"padding within a group": {
+			"AA=AAAAB",
+			trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"),
+		},
+	} {
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/StreamingInputCall", strings.NewReader(test.body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		req.Header.Set("accept", grpcweb.ContentTypeGRPCWeb)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, test.expected, data, name)
+	}
+}
+
+func TestUnencodedText(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	// a SimpleRequest with a response size of 1, sent as a native gRPC frame
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x10, 0x01}
+
+	for name, test := range map[string]struct {
+		opts     []grpcweb.Option
+		body     []byte
+		expected []byte
+	}{
+		"strict": {
+			nil,
+			frame,
+			trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"),
+		},
+		"unencoded": {
+			[]grpcweb.Option{grpcweb.WithUnencodedText()},
+			frame,
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x05, 0x0a, 0x03, 0x12, 0x01, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+		"encoded": {
+			[]grpcweb.Option{grpcweb.WithUnencodedText()},
+			[]byte(base64.StdEncoding.EncodeToString(frame)),
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x05, 0x0a, 0x03, 0x12, 0x01, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", bytes.NewReader(test.body))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(server, test.opts...).ServeHTTP(rec, req)
+
+		// the response is still base64 encoded, as the content-type asks
+		assert.Equal(t, grpcweb.ContentTypeGRPCWebText, rec.Header().Get("content-type"), name)
+		assert.Equal(t, test.expected, decodeSegments(t, rec.Body.String()), name)
+	}
+}
+
+func TestMaxFrameBytes(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for name, test := range map[string]struct {
+		body     []byte
+		expected []byte
+	}{
+		"within": {
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x10, 0x01},
+			append([]byte{0x00, 0x00, 0x00, 0x00, 0x05, 0x0a, 0x03, 0x12, 0x01, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		},
+		"oversized": {
+			// a frame declaring a 2GiB message, none of which is sent
+			[]byte{0x00, 0x7f, 0xff, 0xff, 0xff},
+			trailerFrame("grpc-message: request message frame exceeds the maximum frame size\r\ngrpc-status: 3\r\n"),
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", bytes.NewReader(test.body))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(server, grpcweb.WithMaxFrameBytes(16)).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+	}
+}
+
+func FuzzDecodeRequest(f *testing.F) {
+	for _, seed := range []string{
+		"AAAAAAIQAQ==",
+		"AAAAAAIQAQ==AAAAAAIQAQ==",
+		"AAAAAAIQ",
+		"AAAAAAIQAQ",
+		"gAAAAAA=",
+		"AX////8=",
+		"AAAAAAIQAQ==!",
+		"",
+	} {
+		f.Add([]byte(seed), false)
+		f.Add([]byte(seed), true)
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte, compressed bool) {
+		var forwarded []byte
+		handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			forwarded, _ = ioutil.ReadAll(req.Body)
+			resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}), grpcweb.WithMaxFrameBytes(1<<20))
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", bytes.NewReader(body))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		if compressed {
+			req.Header.Set("grpc-encoding", "gzip")
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// whatever the body, the response is a framed status
+		assert.Equal(t, http.StatusOK, rec.Code)
+		msgs, trailers, err := grpcwebtest.DecodeTextResponse(rec.Body.Bytes())
+		assert.NoError(t, err)
+		assert.Empty(t, msgs)
+		switch trailers.Get("grpc-status") {
+		case "0":
+		case "3", "12":
+			// invalid, or compressed without an encoding
+			return
+		default:
+			t.Fatalf("unexpected status %q: %s", trailers.Get("grpc-status"), trailers.Get("grpc-message"))
+		}
+
+		// and when it's OK, what was forwarded is made up of complete frames
+		for len(forwarded) > 0 {
+			if !assert.True(t, len(forwarded) >= grpcweb.FrameHeaderLength) {
+				return
+			}
+			length := grpcweb.FrameHeaderLength + int(binary.BigEndian.Uint32(forwarded[1:grpcweb.FrameHeaderLength]))
+			if !assert.True(t, len(forwarded) >= length) {
+				return
+			}
+			forwarded = forwarded[length:]
+		}
+	})
+}
+
+// repeatReader reads data n times, counting the bytes read.
+type repeatReader struct {
+	data []byte
+	n    int
+	off  int
+	read int64
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data[r.off:])
+	r.off += n
+	if r.off == len(r.data) {
+		r.off = 0
+		r.n--
+	}
+	r.read += int64(n)
+
+	return n, nil
+}
+
+func TestStreamingTextRequest(t *testing.T) {
+	frame := append([]byte{0x00, 0x00, 0x00, 0x04, 0x00}, bytes.Repeat([]byte{0x01}, 1024)...)
+	body := &repeatReader{data: []byte(base64.StdEncoding.EncodeToString(frame)), n: 8192}
+
+	var readFirst int64
+	var frames int
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		msg := make([]byte, len(frame))
+		for {
+			if _, err := io.ReadFull(req.Body, msg); err != nil {
+				assert.Equal(t, io.EOF, err)
+				return
+			}
+			assert.Equal(t, frame, msg)
+
+			frames++
+			if frames == 1 {
+				readFirst = body.read
+			}
+		}
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingInputCall", body)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the first frame is delivered having read little more than it from the
+	// ~11MB body, so the body is decoded as it's read rather than up front
+	assert.Equal(t, 8192, frames)
+	assert.True(t, readFirst < 16<<10, "read %d bytes before the first frame was delivered", readFirst)
+}
@@ -1,21 +1,54 @@
 package grpcweb
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
-// gRPC content-types
+// gRPC content-types. The bodies of gRPC-Web requests and responses with a
+// text content-type are base64 encoded. Without a subtype, messages are
+// assumed to be protobuf, so each gRPC-Web content-type is handled the same
+// as its "+proto" variant. The "+json" variants are framed the same way, with
+// the JSON messages left for the handler's codec.
 const (
 	ContentTypeGRPC             = "application/grpc"
 	ContentTypeGRPCWeb          = "application/grpc-web"
 	ContentTypeGRPCWebProto     = "application/grpc-web+proto"
+	ContentTypeGRPCWebJSON      = "application/grpc-web+json"
 	ContentTypeGRPCWebText      = "application/grpc-web-text"
 	ContentTypeGRPCWebTextProto = "application/grpc-web-text+proto"
+	ContentTypeGRPCWebTextJSON  = "application/grpc-web-text+json"
+)
+
+// gRPC-Web frames are prefixed with a header of FrameHeaderLength bytes: a
+// flag byte, followed by the length of the frame's payload as a 4 byte
+// big-endian integer. Message frames have a flag of 0, or CompressedFrameFlag
+// if the message is compressed, and the frame carrying the trailers has
+// TrailerFrameFlag set.
+const (
+	FrameHeaderLength = 5
+
+	CompressedFrameFlag byte = 0x01
+	TrailerFrameFlag    byte = 0x80
 )
 
 const (
@@ -23,18 +56,84 @@ const (
 	headerContentLength      = "content-length"
 	headerTE                 = "te"
 	headerGRPCAcceptEncoding = "grpc-accept-encoding"
+	headerGRPCStatus         = "grpc-status"
+	headerGRPCTimeout        = "grpc-timeout"
+	headerGRPCEncoding       = "grpc-encoding"
+	headerXGRPCWeb           = "x-grpc-web"
 	headerAccept             = "accept"
 	headerTrailer            = "trailer"
+	headerConnection         = "connection"
+	headerRetryAfter         = "retry-after"
 )
 
 type grpcWebHandler struct {
 	handler http.Handler
+	opts    options
+
+	// draining is set to 1 once new gRPC-Web requests are to be rejected
+	draining int32
+}
+
+// hopByHopHeaders are the headers that only apply to the connection a
+// gRPC-Web request arrived on, and so are removed before it's passed to the
+// gRPC handler.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
 }
 
 // Handler returns a http.Handler that wraps a gRPC handler and enables
 // the bridging of a gRPC-Web client to gRPC server.
-func Handler(h http.Handler) http.Handler {
-	return &grpcWebHandler{h}
+//
+// gRPC-Web requests are converted to HTTP/2 requests before being passed to
+// the handler. Hop-by-hop headers (Connection, Proxy-Connection, Keep-Alive,
+// Proxy-Authenticate, Proxy-Authorization, TE, Trailer, Transfer-Encoding,
+// Upgrade and any header named by Connection) and HTTP/2 pseudo-headers are
+// removed, as they describe the HTTP/1.x connection rather than the request.
+// Other headers, including the User-Agent and X-User-Agent sent by browser
+// clients, are passed on, and so are seen by gRPC handlers as metadata.
+func Handler(h http.Handler, opts ...Option) http.Handler {
+	return &grpcWebHandler{handler: h, opts: newOptions(opts)}
+}
+
+// HandlerForServer returns a http.Handler that bridges gRPC-Web clients to
+// the provided gRPC server.
+//
+// The server is served using its ServeHTTP method, which uses Go's HTTP/2
+// implementation rather than gRPC's own. Read
+// https://godoc.org/google.golang.org/grpc#Server.ServeHTTP for the
+// performance implications and features this doesn't support. Native gRPC
+// requests passed to the handler are only served if they arrive over HTTP/2.
+//
+// gRPC-Web requests are restricted to the methods registered with the server
+// at the time this is called, as if by WithKnownMethods. Services should
+// therefore be registered beforehand. Responses to unary methods are buffered
+// and written in one go, as they consist of a single message, unless they
+// exceed the limit set by WithMaxBufferedResponse, when they're streamed
+// instead.
+func HandlerForServer(s *grpc.Server, opts ...Option) http.Handler {
+	if s == nil {
+		panic("grpcweb: HandlerForServer called with nil server")
+	}
+
+	var methods, unary []string
+	for service, info := range s.GetServiceInfo() {
+		for _, method := range info.Methods {
+			methods = append(methods, "/"+service+"/"+method.Name)
+			if !method.IsClientStream && !method.IsServerStream {
+				unary = append(unary, "/"+service+"/"+method.Name)
+			}
+		}
+	}
+
+	return Handler(s, append([]Option{WithKnownMethods(methods), withUnaryMethods(unary)}, opts...)...)
 }
 
 // RootHandler returns a http.Handler that dispatches requests to either a gRPC,
@@ -45,16 +144,33 @@ func Handler(h http.Handler) http.Handler {
 //
 // It's worth reading https://godoc.org/google.golang.org/grpc#Server.ServeHTTP
 // and its notes about any performance/limitation issues with this approach.
-func RootHandler(gRPCHandler http.Handler, fallback http.Handler) http.Handler {
-	gRPCWebHandler := Handler(gRPCHandler)
+//
+// The options provided configure the gRPC-Web handler, and any endpoints they
+// add, such as WithMetricsEndpoint, are served ahead of the fallback.
+func RootHandler(gRPCHandler http.Handler, fallback http.Handler, opts ...Option) http.Handler {
+	gRPCWebHandler := &grpcWebHandler{handler: gRPCHandler, opts: newOptions(opts)}
 
 	fn := func(resp http.ResponseWriter, req *http.Request) {
+		// gRPC-Web requests have the timeout applied alongside their
+		// grpc-timeout
+		if d := gRPCWebHandler.opts.requestTimeout; d > 0 && !IsGRPCWebRequest(req) {
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+
 		switch true {
+		case gRPCWebHandler.opts.bypasses(req):
+			gRPCWebHandler.opts.bypassHandler.ServeHTTP(resp, req)
+
 		case IsGRPCWebRequest(req):
 			gRPCWebHandler.ServeHTTP(resp, req)
 
-		case IsGRPCRequest(req):
-			gRPCHandler.ServeHTTP(resp, req)
+		case gRPCWebHandler.opts.isGRPCRequest(req):
+			gRPCHandler.ServeHTTP(resp, gRPCWebHandler.opts.forwardClientIP(req))
+
+		case gRPCWebHandler.endpoint(req) != nil:
+			gRPCWebHandler.endpoint(req).ServeHTTP(resp, req)
 
 		default:
 			fallback.ServeHTTP(resp, req)
@@ -66,137 +182,1357 @@ func RootHandler(gRPCHandler http.Handler, fallback http.Handler) http.Handler {
 
 func (h *grpcWebHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	if !IsGRPCWebRequest(req) {
+		if endpoint := h.endpoint(req); endpoint != nil {
+			endpoint.ServeHTTP(resp, req)
+			return
+		}
+
 		h.handler.ServeHTTP(resp, req)
 		return
 	}
 
-	// convert to HTTP/2 request
-	req.ProtoMajor = 2
-	req.ProtoMinor = 0
+	h.opts.cors.setHeaders(resp.Header(), req)
 
-	// ensure chunked encoding
-	req.Header.Del(headerContentLength)
+	// a response to a HEAD request has no body, and so no trailer frame, so
+	// it's refused with its status sent as headers, even in strict mode
+	if req.Method == http.MethodHead {
+		resp.Header().Set("Allow", http.MethodPost)
+		setStatus(resp.Header(), codes.Unimplemented, "gRPC-Web requests must be POST, not HEAD")
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req = h.opts.forwardClientIP(req)
+	requestID := h.opts.requestID(resp.Header(), req)
 
-	var isTextRequest bool
-	switch req.Header.Get(headerContentType) {
-	case ContentTypeGRPCWebText, ContentTypeGRPCWebTextProto:
-		isTextRequest = true
+	if h.opts.pathRewrite != nil {
+		u := *req.URL
+		u.Path = h.opts.pathRewrite(u.Path)
+		u.RawPath = ""
+		req.URL = &u
 	}
-	req.Header.Set(headerContentType, ContentTypeGRPC)
 
-	var isTextResponse bool
-	switch req.Header.Get(headerAccept) {
-	case ContentTypeGRPCWebText, ContentTypeGRPCWebTextProto:
-		isTextResponse = true
+	// convert HTTP/1.x requests to HTTP/2 requests
+	if !h.opts.noProtocolRewrite {
+		if req.ProtoMajor < 2 {
+			req.ProtoMajor = 2
+			req.ProtoMinor = 0
+		}
+
+		// the body has already been unchunked, and HTTP/2 has no
+		// Transfer-Encoding
+		req.TransferEncoding = nil
+		removeHopByHopHeaders(req.Header)
+	}
+
+	requestContentType := req.Header.Get(headerContentType)
+	isTextRequest := webContentTypes[requestContentType]
+	req.Header.Set(headerContentType, ContentTypeGRPC+contentSubtype(requestContentType))
+
+	// without an acceptable gRPC-Web media type, the response is encoded the
+	// same way as the request
+	accept := strings.Join(req.Header[http.CanonicalHeaderKey(headerAccept)], ",")
+	isTextResponse, acceptable := acceptsText(accept)
+	if !acceptable {
+		isTextResponse = isTextRequest
 	}
+	sse := h.opts.sse && acceptsMediaType(accept, contentTypeEventStream)
 
-	req.Header.Set(headerTE, "trailers")
-	req.Header.Set(headerGRPCAcceptEncoding, "identity,deflate,gzip")
+	// gzip is the only compressor that gRPC provides, so the only one
+	// advertised besides identity
+	if !h.opts.noProtocolRewrite {
+		req.Header.Set(headerTE, "trailers")
+	}
+	req.Header.Set(headerGRPCAcceptEncoding, "identity,gzip")
+
+	// the deadline is applied to the request context too, so that a status
+	// can still be reported for a handler that runs past it. A request
+	// timeout shorter than the client's replaces it.
+	timeout, ok := parseTimeout(req.Header.Get(headerGRPCTimeout))
+	if d := h.opts.requestTimeout; d > 0 && (!ok || d < timeout) {
+		timeout, ok = d, true
+		req.Header.Set(headerGRPCTimeout, formatTimeout(timeout))
+	}
+	if ok {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	// gzip compressed messages are decompressed, as the handler may not
+	// support the encoding. Other encodings are rejected.
+	var decompress, unsupportedEncoding bool
+	switch encoding := req.Header.Get(headerGRPCEncoding); encoding {
+	case "", "identity":
+	case "gzip":
+		decompress = true
+		req.Header.Del(headerGRPCEncoding)
+	default:
+		unsupportedEncoding = true
+	}
 
+	// the body is counted as it arrives, and once decoded
+	wireIn := &countingReader{r: req.Body}
+	body := &requestBody{Reader: wireIn, closer: req.Body}
+	if isTextRequest && h.opts.unencodedText {
+		// base64 never starts with a byte that a frame's flags can be
+		br := bufio.NewReader(body.Reader)
+		if p, err := br.Peek(1); err == nil && p[0]&^CompressedFrameFlag == 0 {
+			isTextRequest = false
+		}
+		body.Reader = br
+	}
 	if isTextRequest {
-		req.Body = bodyCloser{base64.NewDecoder(base64.StdEncoding, req.Body), req.Body}
+		body.Reader = &base64Reader{r: body.Reader}
 	}
+	decodedIn := &countingReader{r: body.Reader}
+	body.Reader = &frameReader{r: decodedIn, decompress: decompress, max: h.opts.maxFrameBytes}
+	req.Body = body
 
-	contentType := ContentTypeGRPCWebProto
+	// ensure chunked encoding, unless the length of the body passed to the
+	// handler is known
+	contentLength := int64(-1)
+	if h.opts.contentLength || (h.opts.noProtocolRewrite && !isTextRequest) {
+		contentLength = decodedLength(req, body, isTextRequest, decompress)
+	}
+	if contentLength < 0 {
+		req.Header.Del(headerContentLength)
+	} else {
+		req.Header.Set(headerContentLength, strconv.FormatInt(contentLength, 10))
+	}
+	req.ContentLength = contentLength
+
+	// the response content-type mirrors the request's, only differing in
+	// whether it's base64 encoded
+	contentType := ContentTypeGRPCWeb
 	if isTextResponse {
-		contentType = ContentTypeGRPCWebTextProto
+		contentType = ContentTypeGRPCWebText
+	}
+	contentType += contentSubtype(requestContentType)
+	if sse {
+		contentType = contentTypeEventStream
+	}
+
+	method, ok := h.opts.method(req)
+
+	log := requestLogger{h.opts.logger, req.URL.Path, requestContentType}
+	w := &gRPCWebResponseWriter{
+		wrapped:     resp,
+		header:      make(http.Header),
+		contentType: contentType,
+		text:        isTextResponse,
+		continuous:  h.opts.continuousText,
+		sse:         sse,
+		strict:      h.opts.strict,
+		jsonErrors:  h.opts.jsonErrors && acceptsMediaType(accept, contentTypeJSON),
+		ctx:         req.Context(),
+		body:        body,
+		log:         log,
+	}
+	if h.opts.isUnaryMethod(method) {
+		w.buffer = bufferPool.Get().(*bytes.Buffer)
+		w.maxBuffered = h.opts.maxBufferedBytes()
+	}
+	w.requireFlusher = h.opts.requireFlusher
+	if h.opts.maxResponseBytes > 0 {
+		w.limit = &frameLimit{max: h.opts.maxResponseBytes}
+	}
+	if h.opts.errorHandler != nil {
+		w.errorHandler = func(err error) {
+			h.opts.errorHandler(req, err)
+		}
+	}
+
+	start := time.Now()
+	trailers := make(http.Header)
+	defer func() {
+		h.opts.metrics.record(requestContentType, trailers, w.writeErr == nil)
+		recordStatus(req.Context(), trailers, w.writeErr == nil)
+
+		info := RequestInfo{
+			Method:          method,
+			ContentType:     requestContentType,
+			TextRequest:     isTextRequest,
+			TextResponse:    isTextResponse,
+			RequestID:       requestID,
+			WireBytesIn:     wireIn.n,
+			WireBytesOut:    w.wireWritten,
+			DecodedBytesIn:  decodedIn.n,
+			DecodedBytesOut: w.written,
+			Incomplete:      w.writeErr != nil,
+		}
+		h.opts.accessLog.record(start, info, statusCode(trailers))
+		if h.opts.observer != nil {
+			h.opts.observer(info)
+		}
+	}()
+
+	if atomic.LoadInt32(&h.draining) == 1 {
+		setStatus(trailers, h.opts.errorCode(ErrDraining), ErrDraining.Error())
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	if h.opts.inFlight != nil {
+		select {
+		case h.opts.inFlight <- struct{}{}:
+			defer func() { <-h.opts.inFlight }()
+		default:
+			setStatus(trailers, h.opts.errorCode(ErrTooManyRequests), ErrTooManyRequests.Error())
+			w.WriteTrailers(trailers)
+			return
+		}
+	}
+
+	if h.opts.strict && req.Method != http.MethodPost {
+		setStatus(trailers, codes.Unimplemented, "gRPC-Web requests must be POST, not "+req.Method)
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	if h.opts.requireGRPCWebHeader && req.Header.Get(headerXGRPCWeb) == "" {
+		setStatus(trailers, codes.PermissionDenied, "missing x-grpc-web header")
+		h.reject(w, trailers, http.StatusForbidden)
+		return
+	}
+
+	if h.opts.strictAccept && !acceptable && !sse && accept != "" {
+		setStatus(trailers, codes.InvalidArgument, "no acceptable gRPC-Web content-type")
+		h.reject(w, trailers, http.StatusNotAcceptable)
+		return
+	}
+
+	if !ok || !h.opts.isKnownMethod(method) {
+		// without an extracted method, the path is all there is to report
+		name := method
+		if !ok {
+			name = req.URL.Path
+		}
+		setStatus(trailers, codes.Unimplemented, "unknown method "+name)
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	if h.opts.methodFilter != nil && !h.opts.methodFilter(method) {
+		setStatus(trailers, codes.PermissionDenied, "method "+method+" isn't available to gRPC-Web clients")
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	if unsupportedEncoding {
+		setStatus(trailers, h.opts.errorCode(ErrUnsupportedEncoding), fmt.Sprintf("grpc: Decompressor is not installed for grpc-encoding %q", req.Header.Get(headerGRPCEncoding)))
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	if h.opts.health != nil && !h.opts.health.isServing() {
+		setStatus(trailers, codes.Unavailable, "backend unavailable")
+		w.Header().Set(headerRetryAfter, strconv.Itoa(h.opts.health.retryAfter()))
+		if !h.opts.strict {
+			for key, val := range trailers {
+				w.Header()[key] = val
+			}
+			w.writeHeader(http.StatusServiceUnavailable)
+		}
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	var auditRequest, auditResponse *auditBuffer
+	if h.opts.audit.audits(method) {
+		auditRequest = &auditBuffer{max: h.opts.audit.maxBytes()}
+		auditResponse = &auditBuffer{max: h.opts.audit.maxBytes()}
+
+		req.Body = auditBody{req.Body, auditRequest}
+		w.audit = auditResponse
 	}
 
 	// handle request
-	resp = &gRPCWebResponseWriter{wrapped: resp, contentType: contentType}
-	h.handler.ServeHTTP(resp, req)
+	req = req.WithContext(context.WithValue(req.Context(), requestInfoKey{}, RequestInfo{
+		Method:       method,
+		ContentType:  requestContentType,
+		TextRequest:  isTextRequest,
+		TextResponse: isTextResponse,
+		RequestID:    requestID,
+	}))
+	if h.opts.tracer != nil {
+		ctx, span := h.opts.tracer.Start(req, method)
+		req = req.WithContext(ctx)
+		defer func() {
+			span.End(statusCode(trailers), decodeGRPCMessage(trailers.Get("Grpc-Message")))
+		}()
+	}
+	var headerTimedOut int32
+	if h.opts.headerTimeout > 0 {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		req = req.WithContext(ctx)
+
+		timer := time.AfterFunc(h.opts.headerTimeout, func() {
+			if !w.responded() {
+				atomic.StoreInt32(&headerTimedOut, 1)
+				cancel()
+			}
+		})
+		defer timer.Stop()
+	}
+	if h.opts.keepAlive > 0 && w.buffer == nil {
+		// keepalives are only written between frames, which the limit
+		// keeps track of
+		if w.limit == nil {
+			w.limit = &frameLimit{max: math.MaxInt64}
+		}
+		k := startKeepAlive(w, h.opts.keepAlive)
+		h.handler.ServeHTTP(k, req)
+		k.stop()
+	} else {
+		h.handler.ServeHTTP(w, req)
+	}
 
 	// write trailers
+	timedOut := atomic.LoadInt32(&headerTimedOut) == 1
+	disconnected := !timedOut && req.Context().Err() == context.Canceled
+	switch {
+	case timedOut:
+		// the handler's context was cancelled as it hadn't responded
+		setStatus(trailers, h.opts.errorCode(context.DeadlineExceeded), fmt.Sprintf("no response within the header timeout of %s", h.opts.headerTimeout))
+
+	case disconnected:
+		// the client has gone, cancelling the handler's context, so there's
+		// no one to read the trailers
+		log.debugf("client disconnected")
+		setStatus(trailers, codes.Canceled, "client disconnected")
+		w.reportError(context.Canceled)
+
+	case w.failed():
+		// the handler's status is the result of a body we failed to decode,
+		// so is replaced with one that describes the actual problem
+		log.debugf("decoding request body: %v", body.Err())
+		if h.opts.errorHandler != nil {
+			h.opts.errorHandler(req, body.Err())
+		}
+		setStatus(trailers, h.opts.errorCode(body.Err()), body.Err().Error())
+
+	case w.exceeded:
+		// likewise, the handler's status is the result of the writes refused
+		setStatus(trailers, h.opts.errorCode(ErrResponseTooLarge), fmt.Sprintf("response exceeds the maximum of %d bytes", h.opts.maxResponseBytes))
+
+	case w.unflushable:
+		if h.opts.errorHandler != nil {
+			h.opts.errorHandler(req, ErrNotFlushable)
+		}
+		setStatus(trailers, h.opts.errorCode(ErrNotFlushable), ErrNotFlushable.Error())
+
+	case w.nonGRPC:
+		// likewise, the handler's status is replaced, as its response was
+		// discarded
+		code := codes.Unknown
+		if w.statusCode != 0 {
+			code = httpStatusCode(w.statusCode)
+		}
+		setStatus(trailers, code, fmt.Sprintf("handler responded with content-type %q", w.Header().Get(headerContentType)))
+
+	default:
+		trailers = declaredTrailers(w.Header())
+		h.opts.filterTrailers(trailers)
+		if h.opts.strict {
+			normalizeMessage(trailers)
+		}
+
+		switch {
+		case trailers.Get(headerGRPCStatus) != "":
+		case req.Context().Err() == context.DeadlineExceeded:
+			setStatus(trailers, h.opts.errorCode(context.DeadlineExceeded), "deadline exceeded")
+		case w.statusCode != 0:
+			setStatus(trailers, httpStatusCode(w.statusCode), fmt.Sprintf("handler responded with HTTP status %d (%s)", w.statusCode, http.StatusText(w.statusCode)))
+		case strings.HasPrefix(w.Header().Get(headerContentType), ContentTypeGRPC):
+			// a gRPC response without a status usually had its trailers
+			// lost on the way, which an intermediary stripping the TE
+			// header causes
+			log.errorf("gRPC response without a grpc-status trailer, check that the \"TE: trailers\" request header reaches the gRPC server")
+			setStatus(trailers, codes.Internal, "gRPC response without a grpc-status trailer")
+		default:
+			// gRPC-Web clients require a status, which a handler that isn't
+			// a gRPC handler may not have responded with. Without one, the
+			// call can't be assumed to have succeeded.
+			setStatus(trailers, codes.Unknown, "handler responded without a grpc-status")
+		}
+	}
+
+	switch {
+	case disconnected:
+		w.discardBuffer()
+
+	case w.jsonErrors && w.buffer != nil && !w.passthrough && statusCode(trailers) != codes.OK:
+		w.writeJSONError(statusCode(trailers), decodeGRPCMessage(trailers.Get("Grpc-Message")))
+
+	default:
+		h.opts.limitTrailers(trailers, log)
+		w.WriteTrailers(trailers)
+	}
+
+	if auditRequest != nil {
+		h.opts.audit.record(method, auditRequest, auditResponse)
+	}
+}
+
+// decodedLength returns the length of the request body passed to the handler,
+// or -1 if it isn't known. Small base64 encoded bodies are decoded up front to
+// determine their length.
+func decodedLength(req *http.Request, body *requestBody, text, decompressed bool) int64 {
+	if decompressed || req.ContentLength < 0 {
+		return -1
+	}
+
+	if !text {
+		return req.ContentLength
+	}
+
+	if req.ContentLength > maxDecodedContentLength {
+		return -1
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		// the error has been recorded, and is returned by further reads
+		return -1
+	}
+
+	req.Body = decodedBody{bytes.NewReader(data), body}
+	return int64(len(data))
+}
+
+// decodedBody is a request body that has been decoded up front.
+type decodedBody struct {
+	*bytes.Reader
+	io.Closer
+}
+
+// endpoint returns the handler of the endpoint added by an option that the
+// request is for, or nil if it isn't for one.
+func (h *grpcWebHandler) endpoint(req *http.Request) http.Handler {
+	switch {
+	case h.opts.preflightHandler != nil && req.Method == http.MethodOptions:
+		return h.opts.preflightHandler
+
+	case h.opts.cors.isPreflight(req):
+		return http.HandlerFunc(h.opts.cors.servePreflight)
+
+	case h.opts.metrics != nil && isEndpointRequest(req, h.opts.metrics.path):
+		return h.opts.metrics
+
+	case h.opts.reflection != nil && isEndpointRequest(req, h.opts.reflection.path):
+		return h.opts.reflection
+
+	case h.opts.clientConfig != nil && isEndpointRequest(req, h.opts.clientConfig.path):
+		return h.opts.clientConfig
+	}
+
+	return nil
+}
+
+// isEndpointRequest returns whether the request is a GET or HEAD request for
+// the path that isn't a gRPC or gRPC-Web request, and so can't be shadowing a
+// gRPC method.
+func isEndpointRequest(req *http.Request, path string) bool {
+	if req.URL.Path != path {
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return !IsGRPCWebRequest(req) && !IsGRPCRequest(req)
+}
+
+// removeHopByHopHeaders removes hop-by-hop headers, including those listed
+// by the Connection header, and any HTTP/2 pseudo-headers.
+func removeHopByHopHeaders(header http.Header) {
+	for _, field := range header[http.CanonicalHeaderKey(headerConnection)] {
+		for _, name := range strings.Split(field, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	for name := range header {
+		if strings.HasPrefix(name, ":") {
+			delete(header, name)
+		}
+	}
+}
+
+// declaredTrailers returns the trailers announced by the handler, with all of
+// their values. Undeclared trailers, set using http.TrailerPrefix once headers
+// have been written, are included too.
+func declaredTrailers(header http.Header) http.Header {
 	trailers := make(http.Header)
-	for header, val := range resp.Header() {
-		if strings.ToLower(header) == headerTrailer {
-			for _, trailer := range val {
-				field := resp.Header().Get(trailer)
-				if field == "" {
-					continue
+	for key, fields := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			for _, field := range fields {
+				trailers.Add(strings.TrimPrefix(key, http.TrailerPrefix), field)
+			}
+		}
+	}
+
+	for name := range trailerNames(header) {
+		for _, field := range header[name] {
+			if field != "" {
+				trailers.Add(name, field)
+			}
+		}
+	}
+
+	return trailers
+}
+
+// trailerNames returns the canonical names of the trailers declared by the
+// handler. Trailer names can be declared across any number of Trailer
+// headers, each listing one or more comma-separated names, and the same name
+// can be declared more than once.
+func trailerNames(header http.Header) map[string]struct{} {
+	names := make(map[string]struct{})
+	for key, fields := range header {
+		if strings.ToLower(key) != headerTrailer {
+			continue
+		}
+
+		for _, field := range fields {
+			for _, name := range strings.Split(field, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					names[http.CanonicalHeaderKey(name)] = struct{}{}
 				}
+			}
+		}
+	}
+
+	return names
+}
+
+// isTrailer returns whether the header key is a trailer, either one of the
+// declared names or using http.TrailerPrefix, rather than leading metadata.
+func isTrailer(names map[string]struct{}, key string) bool {
+	if strings.ToLower(key) == headerTrailer || strings.HasPrefix(key, http.TrailerPrefix) {
+		return true
+	}
+
+	_, ok := names[http.CanonicalHeaderKey(key)]
+	return ok
+}
+
+// acceptsText returns whether the client's most preferred gRPC-Web media type
+// in the Accept header provided is the base64 encoded text variant. Media types
+// are ordered by their q-value, with ties going to whichever was listed first.
+// If no gRPC-Web media type is acceptable, ok is false.
+func acceptsText(accept string) (text, ok bool) {
+	preferred := 0.0
+
+	for _, field := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(field)
+		if err != nil {
+			continue
+		}
 
-				trailers.Set(trailer, field)
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
 			}
-			break
+		}
+
+		if q <= preferred {
+			continue
+		}
+
+		if isText, known := webContentTypes[mediaType]; known {
+			text, ok, preferred = isText, true, q
 		}
 	}
 
-	buf := new(bytes.Buffer)
-	trailers.Write(buf)
+	return text, ok
+}
 
-	resp.Write([]byte{1 << 7})
-	binary.Write(resp, binary.BigEndian, uint32(buf.Len()))
-	buf.WriteTo(resp)
+// timeoutUnits are the units of a grpc-timeout header value, finest first.
+var timeoutUnits = []struct {
+	unit   time.Duration
+	suffix string
+}{
+	{time.Nanosecond, "n"},
+	{time.Microsecond, "u"},
+	{time.Millisecond, "m"},
+	{time.Second, "S"},
+	{time.Minute, "M"},
+	{time.Hour, "H"},
 }
 
-// IsGRPCWebRequest returns true if the request is for a gRPC-Web handler.
-func IsGRPCWebRequest(req *http.Request) bool {
-	switch req.Header.Get(headerContentType) {
-	case
-		ContentTypeGRPCWeb,
-		ContentTypeGRPCWebProto,
-		ContentTypeGRPCWebText,
-		ContentTypeGRPCWebTextProto:
+// formatTimeout formats a grpc-timeout header value in the finest unit whose
+// value fits in 8 digits.
+func formatTimeout(d time.Duration) string {
+	for _, u := range timeoutUnits {
+		if v := int64(d / u.unit); v < 1e8 {
+			return strconv.FormatInt(v, 10) + u.suffix
+		}
+	}
+
+	return "99999999H"
+}
+
+// acceptsMediaType returns whether the Accept header provided includes the
+// media type with a non-zero q-value.
+func acceptsMediaType(accept, mediaType string) bool {
+	for _, field := range strings.Split(accept, ",") {
+		acceptable, params, err := mime.ParseMediaType(field)
+		if err != nil || acceptable != mediaType {
+			continue
+		}
+
+		if q, ok := params["q"]; ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+
 		return true
+	}
 
+	return false
+}
+
+// parseTimeout parses a grpc-timeout header value: a positive integer of at
+// most 8 digits followed by a unit of H, M, S, m, u or n.
+func parseTimeout(timeout string) (time.Duration, bool) {
+	if len(timeout) < 2 || len(timeout) > 9 {
+		return 0, false
+	}
+
+	var unit time.Duration
+	switch timeout[len(timeout)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
 	default:
-		return false
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(timeout[:len(timeout)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	// timeouts too long to be represented are clamped, as gRPC does
+	if n > uint64(math.MaxInt64/unit) {
+		return math.MaxInt64, true
 	}
+
+	return time.Duration(n) * unit, true
+}
+
+// webContentTypes maps each gRPC-Web content-type to whether its frames are
+// base64 encoded. A content-type without a subtype has protobuf messages, so
+// is handled the same as its "+proto" variant.
+var webContentTypes = map[string]bool{
+	ContentTypeGRPCWeb:          false,
+	ContentTypeGRPCWebProto:     false,
+	ContentTypeGRPCWebJSON:      false,
+	ContentTypeGRPCWebText:      true,
+	ContentTypeGRPCWebTextProto: true,
+	ContentTypeGRPCWebTextJSON:  true,
+}
+
+// contentSubtype returns the subtype of a content-type, including its leading
+// "+", or an empty string if it has none.
+func contentSubtype(contentType string) string {
+	if i := strings.IndexByte(contentType, '+'); i >= 0 {
+		return contentType[i:]
+	}
+
+	return ""
 }
 
-// IsGRPCRequest returns true if the request is for a gRPC handler.
+// IsGRPCWebRequest returns true if the request is for a gRPC-Web handler.
+func IsGRPCWebRequest(req *http.Request) bool {
+	_, ok := webContentTypes[req.Header.Get(headerContentType)]
+	return ok
+}
+
+// IsGRPCRequest returns true if the request is for a gRPC handler. Native gRPC
+// requests arrive over HTTP/2 or HTTP/3.
 func IsGRPCRequest(req *http.Request) bool {
-	return req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get(headerContentType), ContentTypeGRPC)
+	return req.ProtoMajor >= 2 && strings.HasPrefix(req.Header.Get(headerContentType), ContentTypeGRPC)
 }
 
-type bodyCloser struct {
+// maxDecodedContentLength is the largest base64 encoded body decoded up front
+// to determine its length.
+const maxDecodedContentLength = 1 << 20
+
+// requestBody wraps a request body that is decoded and validated before being
+// passed to the gRPC handler. Decoding errors are recorded, as they're fatal
+// to the request and the connection it arrived on. Errors reading the
+// underlying body, such as it having been closed, are only passed on.
+type requestBody struct {
 	io.Reader
 	closer io.Closer
+
+	mu  sync.Mutex
+	err error
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if isDecodeError(err) {
+		b.mu.Lock()
+		b.err = err
+		b.mu.Unlock()
+	}
+
+	return n, err
+}
+
+func (b *requestBody) Close() error {
+	return b.closer.Close()
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// Err returns the first error encountered decoding the body.
+func (b *requestBody) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.err
 }
 
-func (bc bodyCloser) Close() error {
-	return bc.closer.Close()
+// bufferPool holds the buffers trailer frames and unary responses are
+// assembled in.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// maxPooledBuffer is the largest buffer returned to the pool, so that one
+// large response doesn't hold onto its memory indefinitely.
+const maxPooledBuffer = 64 << 10
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// ResponseWriter is a http.ResponseWriter that frames what's written to it as
+// a gRPC-Web response.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Flusher
+
+	// WriteTrailers writes the trailers as the response's final frame. It
+	// should be called once the handler has written its messages.
+	WriteTrailers(trailers http.Header)
+}
+
+// NewResponseWriter returns a ResponseWriter that writes a gRPC-Web response,
+// base64 encoded if text is true, to the http.ResponseWriter provided. The
+// messages written to it are expected to already be length-prefixed, as
+// written by a gRPC handler.
+//
+// This is useful for custom handlers that want to reuse the gRPC-Web framing
+// without the request handling of Handler.
+func NewResponseWriter(w http.ResponseWriter, text bool) ResponseWriter {
+	contentType := ContentTypeGRPCWeb
+	if text {
+		contentType = ContentTypeGRPCWebText
+	}
+
+	return &gRPCWebResponseWriter{
+		wrapped:     w,
+		header:      make(http.Header),
+		contentType: contentType,
+		text:        text,
+		log:         requestLogger{Logger: nopLogger{}},
+	}
 }
 
 type gRPCWebResponseWriter struct {
+	// mu serializes the handler's writes and flushes, which may be made
+	// from different goroutines
+	mu sync.Mutex
+
 	wrapped     http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
 	encoder     io.Writer
 	contentType string
+	text        bool
+	continuous  bool
+	sse         bool
+	ctx         context.Context
+	body        *requestBody
+	audit       *auditBuffer
+	log         requestLogger
+
+	// buffer holds the response until the trailers are written, when set,
+	// or until it would grow beyond maxBuffered bytes.
+	// If it may be replaced by a JSON error, jsonErrors is set, and the HTTP
+	// status the handler writes is held in heldStatus until then.
+	buffer      *bytes.Buffer
+	jsonErrors  bool
+	heldStatus  int
+	maxBuffered int
+
+	// passthrough is set when the handler's response isn't a gRPC response,
+	// and so is written without framing or encoding. In strict mode, nonGRPC
+	// is set instead, and the response discarded.
+	passthrough bool
+	strict      bool
+	nonGRPC     bool
+
+	// limit, when set, limits the size of the messages written, and
+	// exceeded is set once a message has been refused
+	limit    *frameLimit
+	exceeded bool
+
+	// requireFlusher fails a streamed response to a wrapped writer that
+	// isn't a http.Flusher, and unflushable is set once it has been
+	requireFlusher bool
+	checkedFlusher bool
+	unflushable    bool
+
+	// statusCode is the non-200 HTTP status the handler tried to write to a
+	// gRPC-Web response
+	statusCode int
+
+	// errorHandler is called with writeErr, the first error writing the
+	// response, after which the response is incomplete
+	errorHandler func(error)
+	writeErr     error
+
+	// written is the number of bytes written, before any base64 encoding,
+	// and wireWritten the number written to the wrapped writer
+	written     int64
+	wireWritten int64
+
+	// started is set to 1 once the response's headers have been written
+	started int32
 }
 
+// Header returns the handler's headers, which are kept apart from the wrapped
+// writer's so that trailers aren't sent as HTTP headers or trailers.
 func (w *gRPCWebResponseWriter) Header() http.Header {
-	return w.wrapped.Header()
+	return w.header
+}
+
+// setHeaders sets the response headers. The first time it's called, the
+// handler's headers, its leading metadata, are copied to the wrapped writer,
+// leaving any trailers for the trailer frame, and the content-type that the
+// wrapped handler isn't responsible for is set. If the request body couldn't be
+// decoded, the connection is closed once the response completes, rather than
+// being reused.
+func (w *gRPCWebResponseWriter) setHeaders() {
+	header := w.wrapped.Header()
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		atomic.StoreInt32(&w.started, 1)
+
+		names := trailerNames(w.header)
+		for key, val := range w.header {
+			if !isTrailer(names, key) {
+				header[key] = val
+			}
+		}
+
+		// a handler responding with something other than gRPC, such as a
+		// redirect or error page, has its response passed through as is,
+		// unless in strict mode, where it's discarded
+		if contentType := w.header.Get(headerContentType); contentType != "" && !strings.HasPrefix(contentType, ContentTypeGRPC) {
+			if w.strict {
+				w.nonGRPC = true
+				header.Del(headerContentLength)
+			} else {
+				w.passthrough = true
+			}
+		}
+
+		// the content-type is only set before the headers are sent
+		if !w.passthrough {
+			header.Set(headerContentType, w.contentType)
+		}
+	}
+
+	if w.failed() {
+		header.Set(headerConnection, "close")
+	}
 }
 
+// responded returns whether the handler has started its response, which may
+// be checked whilst the handler is running.
+func (w *gRPCWebResponseWriter) responded() bool {
+	return atomic.LoadInt32(&w.started) == 1
+}
+
+func (w *gRPCWebResponseWriter) failed() bool {
+	return w.body != nil && w.body.Err() != nil
+}
+
+// Write discards anything the handler writes once the request body has failed
+// to decode, as the trailers written in its place report the failure.
 func (w *gRPCWebResponseWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.failed() {
+		return len(p), nil
+	}
+
+	if w.exceeded {
+		return 0, ErrResponseTooLarge
+	}
+
+	if w.unflushable {
+		return 0, ErrNotFlushable
+	}
+
+	w.setHeaders()
+	if w.nonGRPC {
+		return len(p), nil
+	}
+
+	if !w.checkedFlusher {
+		w.checkedFlusher = true
+		if err := w.checkFlusher(); err != nil {
+			return 0, err
+		}
+	}
+	if w.limit != nil && !w.passthrough {
+		return w.writeLimited(p)
+	}
+
+	n, err := w.write(p)
+	w.captureAudit(p[:n])
+	if err != nil {
+		w.reportError(err)
+	}
+
+	return n, err
+}
+
+// captureAudit captures the bytes of the response that were written, so that
+// an audit record never contains messages the client wasn't sent.
+func (w *gRPCWebResponseWriter) captureAudit(p []byte) {
+	if w.audit != nil {
+		w.audit.capture(p)
+	}
+}
+
+// checkFlusher reports a streamed response that can't be flushed, as its
+// messages only reach the client once the response completes. If flushing is
+// required, the response is failed.
+func (w *gRPCWebResponseWriter) checkFlusher() error {
+	if w.buffer != nil || w.passthrough {
+		return nil
+	}
+
+	if _, ok := w.wrapped.(http.Flusher); ok {
+		return nil
+	}
+
+	w.log.errorf("%T isn't a http.Flusher, messages are held until the response completes", w.wrapped)
+	if !w.requireFlusher {
+		return nil
+	}
+
+	w.unflushable = true
+	return ErrNotFlushable
+}
+
+// writeLimited writes the frames in p that are within the response's limit,
+// failing once a frame exceeds it.
+func (w *gRPCWebResponseWriter) writeLimited(p []byte) (int, error) {
+	accepted, ok := w.limit.accept(p)
+	if len(accepted) > 0 {
+		n, err := w.write(accepted)
+		w.captureAudit(accepted[:n])
+		if err != nil {
+			w.reportError(err)
+			return 0, err
+		}
+	}
+
+	if !ok {
+		w.exceeded = true
+		return 0, ErrResponseTooLarge
+	}
+
+	return len(p), nil
+}
+
+// reportError passes the first error writing the response to the error
+// handler. Once one write has failed, the client has usually gone, and so the
+// rest will fail too.
+func (w *gRPCWebResponseWriter) reportError(err error) {
+	if w.writeErr != nil {
+		return
+	}
+
+	w.writeErr = err
+	if w.errorHandler != nil {
+		w.errorHandler(err)
+	}
+}
+
+func (w *gRPCWebResponseWriter) write(p []byte) (int, error) {
 	if w.encoder == nil {
-		w.Header().Set(headerContentType, w.contentType)
+		w.setHeaders()
 
-		if w.contentType == ContentTypeGRPCWebTextProto {
-			w.encoder = base64.NewEncoder(base64.StdEncoding, w.wrapped)
-		} else {
-			w.encoder = w.wrapped
+		var out io.Writer = wireWriter{w}
+		if w.buffer != nil {
+			out = bufferedWriter{w}
+		}
+
+		switch {
+		case w.passthrough:
+			w.encoder = out
+		case w.sse:
+			w.encoder = &sseEncoder{w: out}
+		case w.text:
+			w.encoder = base64.NewEncoder(base64.StdEncoding, out)
+		default:
+			w.encoder = out
+		}
+	}
+
+	n, err := w.encoder.Write(p)
+	w.written += int64(n)
+
+	return n, err
+}
+
+// WriteTrailers writes the trailers as the response's final frame.
+func (w *gRPCWebResponseWriter) WriteTrailers(trailers http.Header) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.passthrough {
+		if err := w.flushBuffer(); err != nil {
+			w.reportError(err)
+		}
+		return
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+
+	frame := appendTrailerFrame(buf, trailers)
+
+	// the trailer frame is encoded separately from the messages, and the
+	// encoder closed afterwards, so that neither is left partially encoded
+	err := w.endSegment()
+	if err == nil {
+		_, err = w.write(frame)
+	}
+	if err == nil {
+		err = w.closeEncoder()
+	}
+	if err == nil {
+		err = w.flushBuffer()
+	}
+	if err != nil {
+		w.log.errorf("writing trailers: %v", err)
+		w.reportError(err)
+	}
+}
+
+// discardBuffer discards a buffered response that won't be written.
+func (w *gRPCWebResponseWriter) discardBuffer() {
+	if w.buffer == nil {
+		return
+	}
+
+	putBuffer(w.buffer)
+	w.buffer = nil
+}
+
+// wireWriter counts the bytes written to the wrapped writer.
+type wireWriter struct {
+	w *gRPCWebResponseWriter
+}
+
+func (c wireWriter) Write(p []byte) (int, error) {
+	n, err := c.w.wrapped.Write(p)
+	c.w.wireWritten += int64(n)
+
+	return n, err
+}
+
+// bufferedWriter writes to the response's buffer until it would exceed
+// maxBuffered, after which it writes to the wrapped writer.
+type bufferedWriter struct {
+	w *gRPCWebResponseWriter
+}
+
+func (b bufferedWriter) Write(p []byte) (int, error) {
+	if b.w.buffer != nil && b.w.buffer.Len()+len(p) <= b.w.maxBuffered {
+		return b.w.buffer.Write(p)
+	}
+
+	if err := b.w.flushBuffer(); err != nil {
+		return 0, err
+	}
+
+	return wireWriter{b.w}.Write(p)
+}
+
+// flushBuffer writes a buffered response to the wrapped writer.
+func (w *gRPCWebResponseWriter) flushBuffer() error {
+	if w.buffer == nil {
+		return nil
+	}
+
+	if w.heldStatus != 0 {
+		w.wrapped.WriteHeader(w.heldStatus)
+		w.heldStatus = 0
+	}
+
+	_, err := w.buffer.WriteTo(wireWriter{w})
+	putBuffer(w.buffer)
+	w.buffer = nil
+
+	return err
+}
+
+// BuildTrailerFrame returns the frame that ends a gRPC-Web response, carrying
+// the trailers provided. The trailers are serialized as header fields, sorted
+// by name, with lowercase names, and prefixed with a frame header with
+// TrailerFrameFlag set. Without a grpc-status trailer, one of 0 (OK) is added,
+// so that the frame is never empty. The frame isn't base64 encoded.
+func BuildTrailerFrame(trailers http.Header) []byte {
+	return appendTrailerFrame(new(bytes.Buffer), trailers)
+}
+
+// appendTrailerFrame writes the trailer frame to buf, returning it.
+func appendTrailerFrame(buf *bytes.Buffer, trailers http.Header) []byte {
+	// clients treat a frame without a status, which may be empty, as a
+	// protocol error
+	if trailers.Get(headerGRPCStatus) == "" {
+		trailers = trailers.Clone()
+		if trailers == nil {
+			trailers = make(http.Header)
 		}
+		trailers.Set(headerGRPCStatus, "0")
 	}
 
-	return w.encoder.Write(p)
+	// the frame header is written ahead of the trailers, with the length
+	// filled in once they've been serialized
+	start := buf.Len()
+	buf.Write([]byte{TrailerFrameFlag, 0, 0, 0, 0})
+	writeTrailerFields(buf, trailers)
+
+	frame := buf.Bytes()[start:]
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(frame)-FrameHeaderLength))
+
+	return frame
+}
+
+// trailerValueReplacer replaces the newlines that can't appear in a trailer
+// value, as http.Header's Write does.
+var trailerValueReplacer = strings.NewReplacer("\n", " ", "\r", " ")
+
+// writeTrailerFields serializes the trailers as header fields, sorted by name,
+// with the lowercase names the gRPC-Web specification requires.
+func writeTrailerFields(buf *bytes.Buffer, trailers http.Header) {
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range trailers[name] {
+			buf.WriteString(strings.ToLower(name))
+			buf.WriteString(": ")
+			buf.WriteString(strings.TrimSpace(trailerValueReplacer.Replace(value)))
+			buf.WriteString("\r\n")
+		}
+	}
 }
 
+// endSegment completes the base64 segment written so far, unless the response
+// is a continuous stream.
+func (w *gRPCWebResponseWriter) endSegment() error {
+	if w.continuous {
+		return nil
+	}
+
+	return w.closeEncoder()
+}
+
+// closeEncoder writes out any bytes held by the base64 encoder, padding the
+// encoded segment. The next write starts a new segment.
+func (w *gRPCWebResponseWriter) closeEncoder() error {
+	wc, ok := w.encoder.(io.WriteCloser)
+	if !ok {
+		return nil
+	}
+
+	w.encoder = nil
+	return wc.Close()
+}
+
+// WriteHeader writes the response's headers. gRPC-Web responses are always
+// HTTP 200, with their status in the trailers, so any other HTTP status the
+// handler writes is recorded to be reported in the trailers instead.
 func (w *gRPCWebResponseWriter) WriteHeader(statusCode int) {
-	w.Header().Set(headerContentType, w.contentType)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.setHeaders()
+	if !w.passthrough && statusCode != http.StatusOK {
+		if w.statusCode == 0 {
+			w.statusCode = statusCode
+		}
+		statusCode = http.StatusOK
+	}
+
+	// a buffered response that may be replaced by a JSON error has its
+	// headers written with it
+	if w.jsonErrors && w.buffer != nil {
+		w.heldStatus = statusCode
+		return
+	}
+
+	w.wrapped.WriteHeader(statusCode)
+}
+
+// writeHeader writes the response's headers with the HTTP status provided,
+// for responses that fail before the handler is called.
+func (w *gRPCWebResponseWriter) writeHeader(statusCode int) {
+	w.setHeaders()
 	w.wrapped.WriteHeader(statusCode)
 }
 
+// Flush sends what's been written so far to the client. In text mode, the
+// base64 segment written so far is padded and completed, so that it can be
+// decoded without waiting for more data, and later writes, including the
+// trailer frame, start a new segment. For continuous streams, only complete
+// base64 groups are sent, and the remaining bytes are held until more data is
+// written.
 func (w *gRPCWebResponseWriter) Flush() {
-	if wc, ok := w.encoder.(io.WriteCloser); ok {
-		wc.Close()
-		w.encoder = nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.setHeaders()
+	w.endSegment()
+
+	// buffered responses are only written once complete
+	if w.buffer != nil {
+		return
 	}
 
-	w.wrapped.(http.Flusher).Flush()
+	if flusher, ok := w.wrapped.(http.Flusher); ok {
+		flusher.Flush()
+	} else {
+		w.log.debugf("%T isn't a http.Flusher, response not flushed", w.wrapped)
+	}
 }
 
+// CloseNotify is implemented in terms of the request's context rather than
+// the wrapped writer, which isn't guaranteed to be a http.CloseNotifier.
+// Writers created by NewResponseWriter have no request, so defer to the
+// wrapped writer if they can.
 func (w *gRPCWebResponseWriter) CloseNotify() <-chan bool {
-	return w.wrapped.(http.CloseNotifier).CloseNotify()
+	if w.ctx == nil {
+		if notifier, ok := w.wrapped.(http.CloseNotifier); ok {
+			return notifier.CloseNotify()
+		}
+
+		w.log.debugf("%T isn't a http.CloseNotifier, close never notified", w.wrapped)
+		return make(chan bool, 1)
+	}
+
+	ch := make(chan bool, 1)
+	go func() {
+		<-w.ctx.Done()
+		ch <- true
+	}()
+
+	return ch
+}
+
+// setStatus sets the gRPC status trailers, percent-encoding the message as
+// required by the gRPC specification.
+func setStatus(trailers http.Header, code codes.Code, msg string) {
+	trailers.Set(headerGRPCStatus, strconv.Itoa(int(code)))
+	if msg != "" {
+		trailers.Set("Grpc-Message", encodeGRPCMessage(msg))
+	}
+}
+
+// httpStatusCode returns the gRPC status code of a HTTP status, as mapped by
+// the gRPC specification.
+func httpStatusCode(statusCode int) codes.Code {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	}
+
+	return codes.Unknown
+}
+
+// decodeGRPCMessage decodes a percent-encoded status message, returning it as
+// is if it isn't validly encoded.
+func decodeGRPCMessage(msg string) string {
+	if decoded, err := url.PathUnescape(msg); err == nil {
+		return decoded
+	}
+
+	return msg
+}
+
+func encodeGRPCMessage(msg string) string {
+	var buf strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+
+	return buf.String()
 }
@@ -2,24 +2,50 @@ package grpcweb_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/interop"
 	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// trailerFrame returns the gRPC-Web trailer frame for the serialized trailers.
+func trailerFrame(trailers string) []byte {
+	header := make([]byte, grpcweb.FrameHeaderLength)
+	header[0] = grpcweb.TrailerFrameFlag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(trailers)))
+
+	return append(header, trailers...)
+}
+
 func TestIsGRPCWebRequest(t *testing.T) {
 	supported := []string{
 		grpcweb.ContentTypeGRPCWeb,
 		grpcweb.ContentTypeGRPCWebProto,
+		grpcweb.ContentTypeGRPCWebJSON,
 		grpcweb.ContentTypeGRPCWebText,
 		grpcweb.ContentTypeGRPCWebTextProto,
+		grpcweb.ContentTypeGRPCWebTextJSON,
 	}
 
 	req := &http.Request{}
@@ -53,6 +79,14 @@ func TestIsGRPCRequest(t *testing.T) {
 	req.ProtoMajor = 2
 	req.Header.Set("content-type", grpcweb.ContentTypeGRPC)
 	assert.True(t, grpcweb.IsGRPCRequest(req))
+
+	req.ProtoMajor = 3
+	req.Header.Set("content-type", "unsupported")
+	assert.False(t, grpcweb.IsGRPCRequest(req))
+
+	req.ProtoMajor = 3
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPC)
+	assert.True(t, grpcweb.IsGRPCRequest(req))
 }
 
 func TestInterop(t *testing.T) {
@@ -77,7 +111,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWebText,
 			grpcweb.ContentTypeGRPCWebText,
 			[]byte("AAAAAAA="),
-			[]byte("AAAAAAA=gAAAABBHcnBjLVN0YXR1czogMA0K"),
+			[]byte("AAAAAAA=gAAAABBncnBjLXN0YXR1czogMA0K"),
 		},
 		// emptycall - base64 request, binary response
 		{
@@ -85,7 +119,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWebText,
 			grpcweb.ContentTypeGRPCWeb,
 			[]byte("AAAAAAA="),
-			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
 		},
 		// emptycall - base64 request (no padding, error), binary response
 		{
@@ -93,7 +127,15 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWebText,
 			grpcweb.ContentTypeGRPCWeb,
 			[]byte("AAAAAAA"),
-			[]byte{0x80, 0x00, 0x00, 0x00, 0x2f, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x3a, 0x20, 0x75, 0x6e, 0x65, 0x78, 0x70, 0x65, 0x63, 0x74, 0x65, 0x64, 0x20, 0x45, 0x4f, 0x46, 0x0d, 0x0a, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x31, 0x33, 0x0d, 0x0a},
+			trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"),
+		},
+		// emptycall - base64 request (no padding, error), base64 response
+		{
+			"/grpc.testing.TestService/EmptyCall",
+			grpcweb.ContentTypeGRPCWebText,
+			grpcweb.ContentTypeGRPCWebText,
+			[]byte("AAAAAAA"),
+			[]byte(base64.StdEncoding.EncodeToString(trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"))),
 		},
 		// emptycall - binary request, binary response
 		{
@@ -101,7 +143,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWeb,
 			grpcweb.ContentTypeGRPCWeb,
 			[]byte{0x00, 0x00, 0x00, 0x00, 0x00},
-			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
 		},
 		// unarycall - base64 request, base64 response
 		{
@@ -109,7 +151,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWebText,
 			grpcweb.ContentTypeGRPCWebText,
 			[]byte("AAAAAAQQBSAB"),
-			[]byte("AAAAAAkKBxIFAAAAAAA=gAAAABBHcnBjLVN0YXR1czogMA0K"),
+			[]byte("AAAAAAkKBxIFAAAAAAA=gAAAABBncnBjLXN0YXR1czogMA0K"),
 		},
 		// unarycall - binary request, binary response
 		{
@@ -117,7 +159,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWeb,
 			grpcweb.ContentTypeGRPCWeb,
 			[]byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x05, 0x20, 0x01},
-			[]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
 		},
 		// streamingoutputcall - base64 request, base64 response
 		{
@@ -125,7 +167,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWebText,
 			grpcweb.ContentTypeGRPCWebText,
 			[]byte("AAAAAAgSAggFEgIICg=="),
-			[]byte("AAAAAAkKBxIFAAAAAAA=AAAAAA4KDBIKAAAAAAAAAAAAAA==gAAAABBHcnBjLVN0YXR1czogMA0K"),
+			[]byte("AAAAAAkKBxIFAAAAAAA=AAAAAA4KDBIKAAAAAAAAAAAAAA==gAAAABBncnBjLXN0YXR1czogMA0K"),
 		},
 		// streamingoutputcall - binary request, binary response
 		{
@@ -133,7 +175,7 @@ func TestInterop(t *testing.T) {
 			grpcweb.ContentTypeGRPCWeb,
 			grpcweb.ContentTypeGRPCWeb,
 			[]byte{0x00, 0x00, 0x00, 0x00, 0x08, 0x12, 0x02, 0x08, 0x05, 0x12, 0x02, 0x08, 0x0a},
-			[]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x5, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x0a, 0x0c, 0x12, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x47, 0x72, 0x70, 0x63, 0x2d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x5, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0e, 0x0a, 0x0c, 0x12, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
 		},
 	}
 
@@ -156,3 +198,1870 @@ func TestInterop(t *testing.T) {
 		assert.Equal(t, request.Response, data)
 	}
 }
+
+func TestCloseNotify(t *testing.T) {
+	notified := make(chan bool, 1)
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// httptest.ResponseRecorder isn't a http.CloseNotifier
+		notified <- <-resp.(http.CloseNotifier).CloseNotify()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil).WithContext(ctx)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), req)
+	cancel()
+
+	select {
+	case closed := <-notified:
+		assert.True(t, closed)
+	case <-time.After(time.Second):
+		t.Fatal("close notification not received after request context was cancelled")
+	}
+}
+
+func TestConnectionCloseOnFatalError(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	tests := map[string]bool{
+		"AAAAAAA=": false,
+		"AAAAAAA":  true,
+		"AA$AAAA=": true,
+	}
+
+	for body, closed := range tests {
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+		_, err = ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, closed, resp.Close, body)
+	}
+}
+
+func TestInvalidBase64Request(t *testing.T) {
+	// the handler's own response is discarded in favour of the decode error
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		_, err := ioutil.ReadAll(req.Body)
+		assert.Error(t, err)
+
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		resp.Header().Set("Grpc-Status", "13")
+	}))
+
+	for _, body := range []string{"AAAAAAA", "AA$AAAA="} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewBufferString(body))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		req.Header.Set("accept", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, trailerFrame("grpc-message: invalid base64 request body\r\ngrpc-status: 3\r\n"), rec.Body.Bytes(), body)
+	}
+}
+
+func TestHandlerForServer(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.HandlerForServer(server))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewBufferString("AAAAAAA="))
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	req.Header.Set("accept", grpcweb.ContentTypeGRPCWebText)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("AAAAAAA=gAAAABBncnBjLXN0YXR1czogMA0K"), data)
+
+	assert.Panics(t, func() { grpcweb.HandlerForServer(nil) })
+}
+
+func TestDeclaredTrailers(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Add("Trailer", "Grpc-Message")
+		resp.Header()["trailer"] = []string{"X-Custom", "grpc-status"}
+
+		resp.Header().Set("Grpc-Status", "0")
+		resp.Header().Set("Grpc-Message", "ok")
+		resp.Header().Set("X-Custom", "value")
+		resp.Header().Add("Trailer", "X-Repeated, x-listed ,,")
+		resp.Header().Add("X-Repeated", "first")
+		resp.Header().Add("X-Repeated", "second")
+		resp.Header().Set("X-Listed", "listed")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, trailerFrame("grpc-message: ok\r\ngrpc-status: 0\r\nx-custom: value\r\nx-listed: listed\r\nx-repeated: first\r\nx-repeated: second\r\n"), rec.Body.Bytes())
+	assert.Empty(t, rec.Header().Get("x-listed"))
+}
+
+func TestKnownMethods(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handlers := map[string]http.Handler{
+		"server":   grpcweb.HandlerForServer(server),
+		"explicit": grpcweb.Handler(server, grpcweb.WithKnownMethods([]string{"grpc.testing.TestService/EmptyCall", "/grpc.testing.TestService/UnaryCall"})),
+	}
+
+	for name, handler := range handlers {
+		ts := httptest.NewServer(handler)
+
+		for path, expected := range map[string][]byte{
+			"/grpc.testing.TestService/EmptyCall": {0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+			"/grpc.testing.TestService/Unknown":   trailerFrame("grpc-message: unknown method /grpc.testing.TestService/Unknown\r\ngrpc-status: 12\r\n"),
+			"/unknown":                            trailerFrame("grpc-message: unknown method /unknown\r\ngrpc-status: 12\r\n"),
+		} {
+			req, err := http.NewRequest("POST", ts.URL+path, bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+			assert.NoError(t, err)
+			req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+			resp, err := ts.Client().Do(req)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, grpcweb.ContentTypeGRPCWeb, resp.Header.Get("content-type"))
+
+			data, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, data, name+" "+path)
+		}
+
+		ts.Close()
+	}
+}
+
+func TestResponseContentType(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+	handler := grpcweb.Handler(server)
+
+	tests := []struct {
+		ContentType string
+		Accept      string
+		Response    string
+	}{
+		{grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWeb},
+		{grpcweb.ContentTypeGRPCWebProto, grpcweb.ContentTypeGRPCWebProto, grpcweb.ContentTypeGRPCWebProto},
+		{grpcweb.ContentTypeGRPCWebText, grpcweb.ContentTypeGRPCWebText, grpcweb.ContentTypeGRPCWebText},
+		{grpcweb.ContentTypeGRPCWebTextProto, grpcweb.ContentTypeGRPCWebTextProto, grpcweb.ContentTypeGRPCWebTextProto},
+		{grpcweb.ContentTypeGRPCWebTextProto, grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWebProto},
+		{grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWebTextProto, grpcweb.ContentTypeGRPCWebText},
+	}
+
+	for _, test := range tests {
+		body := "AAAAAAA="
+		if test.ContentType == grpcweb.ContentTypeGRPCWeb || test.ContentType == grpcweb.ContentTypeGRPCWebProto {
+			body = "\x00\x00\x00\x00\x00"
+		}
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewBufferString(body))
+		req.Header.Set("content-type", test.ContentType)
+		req.Header.Set("accept", test.Accept)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, test.Response, rec.Header().Get("content-type"), test.ContentType+" "+test.Accept)
+	}
+}
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	var header http.Header
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("connection", "keep-alive, X-Hop")
+	req.Header.Set("keep-alive", "timeout=5")
+	req.Header.Set("upgrade", "websocket")
+	req.Header.Set("transfer-encoding", "chunked")
+	req.Header.Set("proxy-connection", "keep-alive")
+	req.Header.Set("x-hop", "1")
+	req.Header.Set("x-end-to-end", "1")
+	req.Header[":authority"] = []string{"example.com"}
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, name := range []string{"connection", "keep-alive", "upgrade", "transfer-encoding", "proxy-connection", "x-hop"} {
+		assert.Empty(t, header.Get(name), name)
+	}
+	assert.NotContains(t, header, ":authority")
+	assert.Equal(t, "1", header.Get("x-end-to-end"))
+	assert.Equal(t, "trailers", header.Get("te"))
+}
+
+func TestChunkedRequest(t *testing.T) {
+	type received struct {
+		transferEncoding []string
+		header           string
+		body             []byte
+	}
+	got := make(chan received, 1)
+	ts := httptest.NewServer(grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		assert.NoError(t, err)
+		got <- received{req.TransferEncoding, req.Header.Get("transfer-encoding"), body}
+	})))
+	defer ts.Close()
+
+	// a body of unknown length is sent chunked
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("AAAAAAIIAQ=="))
+		pw.Write([]byte("AAAAAAIIAg=="))
+		pw.Close()
+	}()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/StreamingInputCall", pr)
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	r := <-got
+	assert.Empty(t, r.transferEncoding)
+	assert.Empty(t, r.header)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x01, 0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x02}, r.body)
+}
+
+func TestWithoutProtocolRewrite(t *testing.T) {
+	var got *http.Request
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		got = req
+	}), grpcweb.WithoutProtocolRewrite())
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0, 0, 0, 0, 0}))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("connection", "keep-alive")
+	req.Header.Set("content-length", "5")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, 1, got.ProtoMajor)
+	assert.Equal(t, "keep-alive", got.Header.Get("connection"))
+	assert.Empty(t, got.Header.Get("te"))
+	assert.Equal(t, int64(5), got.ContentLength)
+	assert.Equal(t, "5", got.Header.Get("content-length"))
+	assert.Equal(t, grpcweb.ContentTypeGRPC, got.Header.Get("content-type"))
+
+	// text requests are still decoded, so their length isn't known
+	req = httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAA="))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	req.Header.Set("content-length", "8")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, 1, got.ProtoMajor)
+	assert.Equal(t, int64(-1), got.ContentLength)
+	assert.Empty(t, got.Header.Get("content-length"))
+}
+
+func TestMethodExtractor(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	// the handler is mounted beneath /api, with the prefix only stripped for
+	// the gRPC server
+	handler := grpcweb.Handler(http.StripPrefix("/api", server),
+		grpcweb.WithKnownMethods([]string{"/grpc.testing.TestService/EmptyCall"}),
+		grpcweb.WithMethodExtractor(func(req *http.Request) (string, bool) {
+			if !strings.HasPrefix(req.URL.Path, "/api/") {
+				return "", false
+			}
+			return strings.TrimPrefix(req.URL.Path, "/api"), true
+		}),
+	)
+
+	tests := map[string][]byte{
+		"/api/grpc.testing.TestService/EmptyCall": {0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+		"/api/grpc.testing.TestService/UnaryCall": trailerFrame("grpc-message: unknown method /grpc.testing.TestService/UnaryCall\r\ngrpc-status: 12\r\n"),
+		"/grpc.testing.TestService/EmptyCall":     trailerFrame("grpc-message: unknown method /grpc.testing.TestService/EmptyCall\r\ngrpc-status: 12\r\n"),
+	}
+
+	for path, expected := range tests {
+		req := httptest.NewRequest("POST", path, bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Body.Bytes(), path)
+	}
+}
+
+func TestNewResponseWriter(t *testing.T) {
+	for text, expected := range map[bool]string{
+		false: "\x00\x00\x00\x00\x02\x08\x01" + string(trailerFrame("grpc-status: 0\r\n")),
+		true:  "AAAAAAIIAQ==gAAAABBncnBjLXN0YXR1czogMA0K",
+	} {
+		rec := httptest.NewRecorder()
+
+		w := grpcweb.NewResponseWriter(rec, text)
+		w.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x01})
+		w.Flush()
+		w.WriteTrailers(http.Header{"Grpc-Status": {"0"}})
+
+		contentType := grpcweb.ContentTypeGRPCWeb
+		if text {
+			contentType = grpcweb.ContentTypeGRPCWebText
+		}
+
+		assert.Equal(t, contentType, rec.Header().Get("content-type"))
+		assert.Equal(t, expected, rec.Body.String())
+	}
+}
+
+func TestAcceptHeader(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+
+	for accept, expected := range map[string]string{
+		"":                                grpcweb.ContentTypeGRPCWeb,
+		"application/grpc-web-text":       grpcweb.ContentTypeGRPCWebText,
+		"application/grpc-web-text+proto": grpcweb.ContentTypeGRPCWebText,
+		"application/grpc-web-text, application/grpc-web":                 grpcweb.ContentTypeGRPCWebText,
+		"application/grpc-web, application/grpc-web-text":                 grpcweb.ContentTypeGRPCWeb,
+		"application/grpc-web;q=0.5, application/grpc-web-text;q=0.8":     grpcweb.ContentTypeGRPCWebText,
+		"application/grpc-web-text;q=0.2, application/grpc-web":           grpcweb.ContentTypeGRPCWeb,
+		"application/grpc-web-text;q=0, */*":                              grpcweb.ContentTypeGRPCWeb,
+		"text/html, Application/GRPC-Web-Text; charset=utf-8":             grpcweb.ContentTypeGRPCWebText,
+		"application/grpc-web-text;q=invalid, application/grpc-web;q=0.1": grpcweb.ContentTypeGRPCWeb,
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("accept", accept)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Header().Get("content-type"), accept)
+	}
+
+	// without an acceptable gRPC-Web media type, the request's encoding is used
+	for _, accept := range []string{"", "*/*", "application/grpc-web;q=0"} {
+		for _, contentType := range []string{grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWebText} {
+			req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+			req.Header.Set("content-type", contentType)
+			if accept != "" {
+				req.Header.Set("accept", accept)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, contentType, rec.Header().Get("content-type"), accept)
+		}
+	}
+}
+
+type metadataServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (metadataServer) EmptyCall(ctx context.Context, _ *testpb.Empty) (*testpb.Empty, error) {
+	grpc.SendHeader(ctx, metadata.Pairs("x-leading", "header"))
+	grpc.SetTrailer(ctx, metadata.Pairs("x-trailing", "trailer"))
+
+	return &testpb.Empty{}, nil
+}
+
+func TestUserAgentMetadata(t *testing.T) {
+	var md metadata.MD
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAA="))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	req.Header.Set("user-agent", "Mozilla/5.0")
+	req.Header.Set("x-user-agent", "grpc-web-javascript/0.1")
+
+	rec := httptest.NewRecorder()
+	grpcweb.Handler(server).ServeHTTP(rec, req)
+
+	_, trailers, err := grpcwebtest.DecodeTextResponse(rec.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "0", trailers.Get("grpc-status"))
+	assert.Equal(t, []string{"Mozilla/5.0"}, md.Get("user-agent"))
+	assert.Equal(t, []string{"grpc-web-javascript/0.1"}, md.Get("x-user-agent"))
+}
+
+func TestMetadata(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, metadataServer{})
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	// leading metadata is sent as headers, and trailing metadata only in the
+	// trailer frame
+	assert.Equal(t, "header", resp.Header.Get("x-leading"))
+	assert.Empty(t, resp.Header.Get("x-trailing"))
+	assert.Empty(t, resp.Header.Get("grpc-status"))
+	assert.Empty(t, resp.Trailer)
+
+	expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-status: 0\r\nx-trailing: trailer\r\n")...)
+	assert.Equal(t, expected, data)
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Add("Trailer", "Grpc-Message")
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		resp.Header().Set("Grpc-Status", "0")
+		resp.Header().Set("Grpc-Message", "ok")
+	}))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestLargeTextResponse(t *testing.T) {
+	message := append([]byte{0x00, 0x00, 0x00, 0x10, 0x01}, bytes.Repeat([]byte("0123456789"), 410)[:4097]...)
+
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Add("Trailer", "Grpc-Message")
+
+		// written without flushing, leaving the encoder holding the last bytes
+		resp.Write(message[:1000])
+		resp.Write(message[1000:])
+
+		resp.Header().Set("Grpc-Status", "0")
+		resp.Header().Set("Grpc-Message", "large")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	expected := base64.StdEncoding.EncodeToString(message) +
+		base64.StdEncoding.EncodeToString(trailerFrame("grpc-message: large\r\ngrpc-status: 0\r\n"))
+	assert.Equal(t, expected, rec.Body.String())
+}
+
+// decodeSegments decodes a text response made up of independently padded
+// base64 segments.
+func decodeSegments(t *testing.T, text string) []byte {
+	data, err := grpcwebtest.DecodeText([]byte(text))
+	assert.NoError(t, err)
+
+	return data
+}
+
+func TestTextResponseFlushes(t *testing.T) {
+	messages := [][]byte{
+		{0x00, 0x00, 0x00, 0x00, 0x01, 0x01},
+		{0x00, 0x00, 0x00, 0x00, 0x02, 0x02, 0x02},
+		{0x00, 0x00, 0x00, 0x00, 0x03, 0x03, 0x03, 0x03},
+	}
+
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.(http.Flusher).Flush()
+
+		for _, message := range messages {
+			resp.Write(message)
+			resp.(http.Flusher).Flush()
+			resp.(http.Flusher).Flush()
+		}
+
+		resp.Header().Set("Grpc-Status", "0")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	expected := append(bytes.Join(messages, nil), trailerFrame("grpc-status: 0\r\n")...)
+	assert.Equal(t, expected, decodeSegments(t, rec.Body.String()))
+}
+
+func TestDeadlineExceeded(t *testing.T) {
+	for name, test := range map[string]struct {
+		handler  http.HandlerFunc
+		expected []byte
+	}{
+		"no status": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+			},
+			trailerFrame("grpc-message: deadline exceeded\r\ngrpc-status: 4\r\n"),
+		},
+		"status": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				<-req.Context().Done()
+
+				resp.Header().Add("Trailer", "Grpc-Status")
+				resp.Header().Set("Grpc-Status", "1")
+			},
+			trailerFrame("grpc-status: 1\r\n"),
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("grpc-timeout", "10m")
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(test.handler).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+	}
+
+	// an invalid timeout is left to the wrapped handler
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("grpc-timeout", "10x")
+
+	grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		_, ok := req.Context().Deadline()
+		assert.False(t, ok)
+	})).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestLongTimeout(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	// the longest timeout allowed overflows a time.Duration, so is clamped
+	// rather than expiring at once
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	req.Header.Set("grpc-timeout", "99999999H")
+
+	rec := httptest.NewRecorder()
+	grpcweb.Handler(server).ServeHTTP(rec, req)
+
+	_, trailers, err := grpcwebtest.DecodeResponse(rec.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "0", trailers.Get("grpc-status"), trailers.Get("grpc-message"))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	type deadline struct {
+		remaining time.Duration
+		header    string
+	}
+	deadlines := make(chan deadline, 1)
+	record := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		d, ok := req.Context().Deadline()
+		assert.True(t, ok)
+		deadlines <- deadline{time.Until(d), req.Header.Get("grpc-timeout")}
+	})
+	handler := grpcweb.RootHandler(record, record, grpcweb.WithRequestTimeout(time.Minute))
+
+	for name, test := range map[string]struct {
+		contentType string
+		timeout     string
+		max         time.Duration
+		header      string
+	}{
+		"gRPC-Web":                      {grpcweb.ContentTypeGRPCWeb, "", time.Minute, "60000000u"},
+		"gRPC-Web with longer timeout":  {grpcweb.ContentTypeGRPCWeb, "2M", time.Minute, "60000000u"},
+		"gRPC-Web with shorter timeout": {grpcweb.ContentTypeGRPCWeb, "10S", 10 * time.Second, "10S"},
+		"gRPC-Web with longest timeout": {grpcweb.ContentTypeGRPCWeb, "99999999H", time.Minute, "60000000u"},
+		"gRPC":                          {grpcweb.ContentTypeGRPC, "", time.Minute, ""},
+		"fallback":                      {"", "", time.Minute, ""},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.ProtoMajor = 2
+		req.Header.Set("content-type", test.contentType)
+		if test.timeout != "" {
+			req.Header.Set("grpc-timeout", test.timeout)
+		}
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		d := <-deadlines
+		assert.True(t, d.remaining <= test.max && d.remaining > test.max-time.Second, name)
+		assert.Equal(t, test.header, d.header, name)
+	}
+}
+
+// flushRecorder counts the number of times the response is flushed.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+func TestUnaryBuffering(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for path, body := range map[string]string{
+		"/grpc.testing.TestService/EmptyCall":           "AAAAAAA=",
+		"/grpc.testing.TestService/UnaryCall":           "AAAAAAQQBSAB",
+		"/grpc.testing.TestService/StreamingOutputCall": "AAAAAAgSAggFEgIICg==",
+	} {
+		var responses []string
+		var flushes []int
+		for _, handler := range []http.Handler{grpcweb.Handler(server), grpcweb.HandlerForServer(server)} {
+			req := httptest.NewRequest("POST", path, strings.NewReader(body))
+			req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+			rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(rec, req)
+
+			responses = append(responses, rec.Body.String())
+			flushes = append(flushes, rec.flushes)
+		}
+
+		// the responses are identical, but unary responses are never flushed
+		// before they're complete
+		assert.Equal(t, responses[0], responses[1], path)
+		if strings.HasSuffix(path, "StreamingOutputCall") {
+			assert.Equal(t, flushes[0], flushes[1], path)
+		} else {
+			assert.NotZero(t, flushes[0], path)
+			assert.Zero(t, flushes[1], path)
+		}
+	}
+}
+
+// writeCountRecorder counts the number of writes to the response.
+type writeCountRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (r *writeCountRecorder) Write(p []byte) (int, error) {
+	r.writes++
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestLargeUnaryResponseStreamed(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for name, test := range map[string]struct {
+		size     int32
+		opts     []grpcweb.Option
+		buffered bool
+	}{
+		"small":         {1024, nil, true},
+		"large":         {256 << 10, nil, false},
+		"raised limit":  {256 << 10, []grpcweb.Option{grpcweb.WithMaxBufferedResponse(1 << 20)}, true},
+		"lowered limit": {1024, []grpcweb.Option{grpcweb.WithMaxBufferedResponse(512)}, false},
+		"default limit": {1024, []grpcweb.Option{grpcweb.WithMaxBufferedResponse(0)}, true},
+	} {
+		msg, err := proto.Marshal(&testpb.SimpleRequest{ResponseSize: test.size})
+		assert.NoError(t, err)
+		body := grpcwebtest.EncodeTextRequest(msg)
+
+		var responses []string
+		var writes []int
+		for _, handler := range []http.Handler{grpcweb.Handler(server), grpcweb.HandlerForServer(server, test.opts...)} {
+			req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", bytes.NewReader(body))
+			req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+			rec := &writeCountRecorder{ResponseRecorder: httptest.NewRecorder()}
+			handler.ServeHTTP(rec, req)
+
+			responses = append(responses, rec.Body.String())
+			writes = append(writes, rec.writes)
+		}
+
+		// the responses are identical, but a unary response larger than the
+		// limit is streamed rather than written in one go
+		assert.Equal(t, responses[0], responses[1], name)
+		if test.buffered {
+			assert.Equal(t, 1, writes[1], name)
+		} else {
+			assert.Greater(t, writes[1], 1, name)
+		}
+
+		msgs, _, err := grpcwebtest.DecodeTextResponse([]byte(responses[1]))
+		assert.NoError(t, err, name)
+		assert.Len(t, msgs, 1, name)
+	}
+}
+
+func BenchmarkUnary(b *testing.B) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for name, handler := range map[string]http.Handler{
+		"streamed": grpcweb.Handler(server),
+		"buffered": grpcweb.HandlerForServer(server),
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", strings.NewReader("AAAAAAQQBSAB"))
+				req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func BenchmarkTextMode(b *testing.B) {
+	msg := append([]byte{0x00, 0x00, 0x01, 0x00, 0x00}, bytes.Repeat([]byte{0x01}, 64<<10)...)
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		io.Copy(resp, req.Body)
+	}))
+
+	for name, test := range map[string]struct {
+		contentType string
+		body        []byte
+	}{
+		"binary": {grpcweb.ContentTypeGRPCWeb, msg},
+		"text":   {grpcweb.ContentTypeGRPCWebText, []byte(base64.StdEncoding.EncodeToString(msg))},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(msg)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("POST", "/grpc.testing.TestService/FullDuplexCall", bytes.NewReader(test.body))
+				req.Header.Set("content-type", test.contentType)
+
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestBypassPaths(t *testing.T) {
+	served := func(name string) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("x-served-by", name)
+		})
+	}
+
+	handler := grpcweb.RootHandler(served("grpc"), served("fallback"), grpcweb.WithBypassPaths(served("probe"), "/healthz", "/readyz"))
+
+	for path, contentTypes := range map[string]map[string]string{
+		"/healthz": {"": "probe", grpcweb.ContentTypeGRPC: "probe", grpcweb.ContentTypeGRPCWeb: "probe"},
+		"/readyz/": {grpcweb.ContentTypeGRPCWebText: "probe"},
+		"/other":   {"": "fallback", grpcweb.ContentTypeGRPC: "grpc"},
+	} {
+		for contentType, expected := range contentTypes {
+			req := httptest.NewRequest("POST", path, nil)
+			req.ProtoMajor = 2
+			req.Header.Set("content-type", contentType)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, expected, rec.Header().Get("x-served-by"), path+" "+contentType)
+		}
+	}
+}
+
+func TestGRPCDetector(t *testing.T) {
+	served := func(name string) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Set("x-served-by", name)
+		})
+	}
+
+	handler := grpcweb.RootHandler(served("grpc"), served("fallback"), grpcweb.WithGRPCDetector(func(req *http.Request) bool {
+		return grpcweb.IsGRPCRequest(req) && !strings.HasPrefix(req.URL.Path, "/api/")
+	}))
+
+	for path, expected := range map[string]string{
+		"/grpc.testing.TestService/EmptyCall": "grpc",
+		"/api/v1/things":                      "fallback",
+	} {
+		req := httptest.NewRequest("POST", path, nil)
+		req.ProtoMajor = 2
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPC)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Header().Get("x-served-by"), path)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	var errs []string
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("x-read") != "" {
+			ioutil.ReadAll(req.Body)
+			return
+		}
+
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+	}), grpcweb.WithErrorHandler(func(req *http.Request, err error) {
+		errs = append(errs, req.URL.Path+": "+err.Error())
+	}))
+
+	// failed writes are only reported once
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	handler.ServeHTTP(&failingWriter{header: make(http.Header)}, req)
+	assert.Equal(t, []string{"/grpc.testing.TestService/EmptyCall: connection reset"}, errs)
+
+	errs = nil
+	req = httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", strings.NewReader("AA$AAAA="))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	req.Header.Set("x-read", "1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, []string{"/grpc.testing.TestService/UnaryCall: invalid base64 request body"}, errs)
+
+	errs = nil
+	req = httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, errs)
+}
+
+type failingServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (failingServer) UnaryCall(context.Context, *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return nil, status.Error(codes.PermissionDenied, "denied")
+}
+
+func TestTrailersOnlyResponse(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, failingServer{})
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	for contentType, test := range map[string]struct {
+		body     string
+		expected string
+	}{
+		grpcweb.ContentTypeGRPCWeb:     {"\x00\x00\x00\x00\x00", string(trailerFrame("grpc-message: denied\r\ngrpc-status: 7\r\n"))},
+		grpcweb.ContentTypeGRPCWebText: {"AAAAAAA=", base64.StdEncoding.EncodeToString(trailerFrame("grpc-message: denied\r\ngrpc-status: 7\r\n"))},
+	} {
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/UnaryCall", strings.NewReader(test.body))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", contentType)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, contentType, resp.Header.Get("content-type"))
+		assert.Empty(t, resp.Header.Get("grpc-status"))
+		assert.Equal(t, test.expected, string(data))
+	}
+}
+
+func TestStrictAccept(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Set("Grpc-Status", "0")
+	}), grpcweb.WithStrictAccept())
+
+	for accept, code := range map[string]int{
+		"":                                     http.StatusOK,
+		"application/grpc-web":                 http.StatusOK,
+		"text/html, application/grpc-web-text": http.StatusOK,
+		"*/*":                                  http.StatusNotAcceptable,
+		"application/grpc-web;q=0":             http.StatusNotAcceptable,
+		"application/json":                     http.StatusNotAcceptable,
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		if accept != "" {
+			req.Header.Set("accept", accept)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, code, rec.Code, accept)
+		if code == http.StatusNotAcceptable {
+			assert.Equal(t, "3", rec.Header().Get("grpc-status"))
+			assert.Empty(t, rec.Body.Bytes())
+		}
+	}
+}
+
+// chunkRecorder records the response body sent at each flush.
+type chunkRecorder struct {
+	*httptest.ResponseRecorder
+	chunks []string
+	sent   int
+}
+
+func (r *chunkRecorder) Flush() {
+	r.chunks = append(r.chunks, r.Body.String()[r.sent:])
+	r.sent = r.Body.Len()
+}
+
+func TestContinuousText(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server, grpcweb.WithContinuousText())
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", strings.NewReader("AAAAAAgSAggFEgIICg=="))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	rec := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(rec, req)
+
+	// every flushed chunk is made up of complete groups, and the response
+	// decodes as one stream
+	assert.NotEmpty(t, rec.chunks)
+	for _, chunk := range rec.chunks {
+		assert.Zero(t, len(chunk)%4, chunk)
+		assert.NotContains(t, chunk, "=")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	assert.NoError(t, err)
+	assert.Equal(t, append([]byte{
+		0x00, 0x00, 0x00, 0x00, 0x09, 0x0a, 0x07, 0x12, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x0e, 0x0a, 0x0c, 0x12, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}, trailerFrame("grpc-status: 0\r\n")...), data)
+}
+
+func TestTextResponseChunks(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	// responses of 1, 2 and 3 bytes, so that every length of partial group is
+	// flushed
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", strings.NewReader("AAAAAAwSAggBEgIIAhICCAM="))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	rec := &chunkRecorder{ResponseRecorder: httptest.NewRecorder()}
+	grpcweb.Handler(server).ServeHTTP(rec, req)
+	rec.Flush()
+
+	// every chunk is made up of complete, padded groups, and decodes to
+	// complete frames on its own, so a client can decode each as it arrives
+	assert.True(t, len(rec.chunks) > 3)
+	var msgs int
+	for _, chunk := range rec.chunks {
+		if chunk == "" {
+			continue
+		}
+		assert.Zero(t, len(chunk)%4, chunk)
+
+		data, err := base64.StdEncoding.DecodeString(chunk)
+		assert.NoError(t, err, chunk)
+
+		for len(data) > 0 {
+			if !assert.True(t, len(data) >= grpcweb.FrameHeaderLength, chunk) {
+				break
+			}
+			length := grpcweb.FrameHeaderLength + int(binary.BigEndian.Uint32(data[1:grpcweb.FrameHeaderLength]))
+			if !assert.True(t, len(data) >= length, chunk) {
+				break
+			}
+			if data[0]&grpcweb.TrailerFrameFlag == 0 {
+				msgs++
+			}
+			data = data[length:]
+		}
+	}
+	assert.Equal(t, 3, msgs)
+}
+
+func TestContentLength(t *testing.T) {
+	type result struct {
+		header string
+		length int64
+		body   string
+	}
+
+	var got result
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		got = result{req.Header.Get("content-length"), req.ContentLength, string(data)}
+	})
+
+	for _, test := range []struct {
+		opts        []grpcweb.Option
+		contentType string
+		body        string
+		expected    result
+	}{
+		{nil, grpcweb.ContentTypeGRPCWeb, "\x00\x00\x00\x00\x01\x01", result{"", -1, "\x00\x00\x00\x00\x01\x01"}},
+		{nil, grpcweb.ContentTypeGRPCWebText, "AAAAAAEB", result{"", -1, "\x00\x00\x00\x00\x01\x01"}},
+		{[]grpcweb.Option{grpcweb.WithContentLength()}, grpcweb.ContentTypeGRPCWeb, "\x00\x00\x00\x00\x01\x01", result{"6", 6, "\x00\x00\x00\x00\x01\x01"}},
+		{[]grpcweb.Option{grpcweb.WithContentLength()}, grpcweb.ContentTypeGRPCWebText, "AAAAAAEB", result{"6", 6, "\x00\x00\x00\x00\x01\x01"}},
+		{[]grpcweb.Option{grpcweb.WithContentLength()}, grpcweb.ContentTypeGRPCWebText, "AAAAAAA=", result{"5", 5, "\x00\x00\x00\x00\x00"}},
+		{[]grpcweb.Option{grpcweb.WithContentLength()}, grpcweb.ContentTypeGRPCWebText, "AA$AAAA=", result{"", -1, ""}},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", strings.NewReader(test.body))
+		req.Header.Set("content-type", test.contentType)
+		req.Header.Set("content-length", strconv.Itoa(len(test.body)))
+
+		got = result{}
+		grpcweb.Handler(handler, test.opts...).ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, test.expected, got, test.body)
+	}
+}
+
+func TestRequireGrpcWebHeader(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Set("Grpc-Status", "0")
+	}), grpcweb.WithRequireGrpcWebHeader())
+
+	for header, code := range map[string]int{
+		"":  http.StatusForbidden,
+		"1": http.StatusOK,
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		if header != "" {
+			req.Header.Set("x-grpc-web", header)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, code, rec.Code, header)
+		if code == http.StatusForbidden {
+			assert.Equal(t, "7", rec.Header().Get("grpc-status"))
+			assert.Empty(t, rec.Body.Bytes())
+		}
+	}
+}
+
+// Binary metadata is sent by browsers base64 encoded, as it is over HTTP/2,
+// and so is decoded by the gRPC server rather than the handler.
+func TestBinaryMetadata(t *testing.T) {
+	var received []string
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		received = md.Get("trace-bin")
+
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for _, encoded := range []string{"AAH/", "AAE=", "AAE"} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAA="))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		req.Header.Set("trace-bin", encoded)
+
+		received = nil
+		grpcweb.Handler(server).ServeHTTP(httptest.NewRecorder(), req)
+
+		decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{string(decoded)}, received, encoded)
+	}
+}
+
+func TestLowercaseTrailers(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	w := grpcweb.NewResponseWriter(rec, false)
+	w.WriteTrailers(http.Header{
+		"Grpc-Status":  {"0"},
+		"X-Custom-Key": {" multi\nline ", "second"},
+	})
+
+	assert.Equal(t, string(trailerFrame("grpc-status: 0\r\nx-custom-key: multi line\r\nx-custom-key: second\r\n")), rec.Body.String())
+}
+
+func TestBuildTrailerFrame(t *testing.T) {
+	frame := grpcweb.BuildTrailerFrame(http.Header{
+		"X-Custom-Key": {"value"},
+		"Grpc-Status":  {"5"},
+		"Grpc-Message": {"not found"},
+	})
+
+	assert.Equal(t, []byte{grpcweb.TrailerFrameFlag, 0x00, 0x00, 0x00, 0x3e}, frame[:grpcweb.FrameHeaderLength])
+	assert.Equal(t, "grpc-message: not found\r\ngrpc-status: 5\r\nx-custom-key: value\r\n", string(frame[grpcweb.FrameHeaderLength:]))
+
+	// a frame is never empty, even without trailers
+	assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), grpcweb.BuildTrailerFrame(nil))
+
+	rec := httptest.NewRecorder()
+	grpcweb.NewResponseWriter(rec, false).WriteTrailers(http.Header{})
+	assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), rec.Body.Bytes())
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+
+		resp.Header().Add("Trailer", "Grpc-Status")
+		resp.Header().Set("Grpc-Status", "0")
+	}), grpcweb.WithMaxConcurrent(1))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve() }()
+	<-started
+
+	// the limit has been reached
+	assert.Equal(t, trailerFrame("grpc-message: too many concurrent requests\r\ngrpc-status: 8\r\n"), serve().Body.Bytes())
+
+	release <- struct{}{}
+	assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), (<-done).Body.Bytes())
+
+	// and is released once the handler returns
+	go func() { done <- serve() }()
+	<-started
+	release <- struct{}{}
+	assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), (<-done).Body.Bytes())
+}
+
+func TestMaxConcurrentUnlimited(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			resp.Header().Add("Trailer", "Grpc-Status")
+			resp.Header().Set("Grpc-Status", "0")
+		}), grpcweb.WithMaxConcurrent(n))
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, trailerFrame("grpc-status: 0\r\n"), rec.Body.Bytes(), n)
+	}
+}
+
+func TestNonGRPCResponse(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+		resp.WriteHeader(http.StatusForbidden)
+		resp.Write([]byte("<h1>forbidden</h1>"))
+	}))
+
+	for _, contentType := range []string{grpcweb.ContentTypeGRPCWeb, grpcweb.ContentTypeGRPCWebText} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", contentType)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("content-type"))
+		assert.Equal(t, "<h1>forbidden</h1>", rec.Body.String())
+	}
+}
+
+func TestInterleavedTextWrites(t *testing.T) {
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("A"))
+		resp.(http.Flusher).Flush()
+		resp.Write([]byte("B"))
+		resp.(http.Flusher).Flush()
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	trailer := base64.StdEncoding.EncodeToString(trailerFrame("grpc-status: 0\r\n"))
+	for name, test := range map[string]struct {
+		opts     []grpcweb.Option
+		expected string
+	}{
+		// each flush completes a padded segment
+		"segmented": {nil, "QQ==" + "Qg==" + trailer},
+		// only the end of the response is padded
+		"continuous": {[]grpcweb.Option{grpcweb.WithContinuousText()}, base64.StdEncoding.EncodeToString(append([]byte("AB"), trailerFrame("grpc-status: 0\r\n")...))},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, test.opts...).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expected, rec.Body.String(), name)
+		assert.Equal(t, append([]byte("AB"), trailerFrame("grpc-status: 0\r\n")...), decodeSegments(t, rec.Body.String()), name)
+	}
+}
+
+func TestConcurrentWriteFlush(t *testing.T) {
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x03, 'a', 'b', 'c'}
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				resp.Write(frame)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				resp.(http.Flusher).Flush()
+			}
+		}()
+		wg.Wait()
+
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	msgs, trailers, err := grpcwebtest.DecodeTextResponse(rec.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 100)
+	assert.Equal(t, "0", trailers.Get("grpc-status"))
+}
+
+// sentHeaderWriter is a http.ResponseWriter that, like net/http's, sends its
+// headers with the first write, after which changes to them are recorded.
+type sentHeaderWriter struct {
+	header http.Header
+	sent   bool
+	body   bytes.Buffer
+}
+
+func (w *sentHeaderWriter) Header() http.Header        { return w.header }
+func (w *sentHeaderWriter) WriteHeader(statusCode int) {}
+func (w *sentHeaderWriter) Flush()                     {}
+
+func (w *sentHeaderWriter) Write(p []byte) (int, error) {
+	if !w.sent {
+		w.sent = true
+		w.header = make(http.Header)
+	}
+
+	return w.body.Write(p)
+}
+
+func TestContentTypeSetOnce(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		for i := 0; i < 3; i++ {
+			resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+			resp.(http.Flusher).Flush()
+		}
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	w := &sentHeaderWriter{header: make(http.Header)}
+	handler.ServeHTTP(w, req)
+
+	// no headers are set once they've been sent, even as each flush starts
+	// a new base64 segment
+	assert.Empty(t, w.header)
+	msgs, _, err := grpcwebtest.DecodeTextResponse(w.body.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+}
+
+func TestRequestProto(t *testing.T) {
+	var proto string
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		proto = fmt.Sprintf("%s %d.%d", req.Proto, req.ProtoMajor, req.ProtoMinor)
+	}))
+
+	for _, test := range []struct {
+		proto    string
+		major    int
+		minor    int
+		expected string
+	}{
+		// HTTP/1.x requests are presented to the handler as HTTP/2
+		{"HTTP/1.1", 1, 1, "HTTP/1.1 2.0"},
+		{"HTTP/1.0", 1, 0, "HTTP/1.0 2.0"},
+		// genuine HTTP/2 and HTTP/3 requests are left alone
+		{"HTTP/2.0", 2, 0, "HTTP/2.0 2.0"},
+		{"HTTP/3.0", 3, 0, "HTTP/3.0 3.0"},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Proto, req.ProtoMajor, req.ProtoMinor = test.proto, test.major, test.minor
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Equal(t, test.expected, proto, test.proto)
+	}
+}
+
+func TestNonOKStatus(t *testing.T) {
+	for name, test := range map[string]struct {
+		handler  http.HandlerFunc
+		expected []byte
+	}{
+		"status": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(http.StatusInternalServerError)
+			},
+			trailerFrame("grpc-message: handler responded with HTTP status 500 (Internal Server Error)\r\ngrpc-status: 2\r\n"),
+		},
+		"mapped status": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.WriteHeader(http.StatusServiceUnavailable)
+			},
+			trailerFrame("grpc-message: handler responded with HTTP status 503 (Service Unavailable)\r\ngrpc-status: 14\r\n"),
+		},
+		"handler status": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.Header().Set("Trailer", "Grpc-Status")
+				resp.WriteHeader(http.StatusInternalServerError)
+				resp.Header().Set("Grpc-Status", "13")
+			},
+			trailerFrame("grpc-status: 13\r\n"),
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(test.handler).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+	}
+}
+
+func TestTrailerFilter(t *testing.T) {
+	var filtered []string
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Internal, X-Public")
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		resp.Header().Set("Grpc-Status", "0")
+		resp.Header().Set("Grpc-Message", "ok")
+		resp.Header().Set("X-Internal", "secret")
+		resp.Header().Set("X-Public", "value")
+		resp.Header().Set(http.TrailerPrefix+"X-Internal-Late", "secret")
+	}), grpcweb.WithTrailerFilter(func(key string) bool {
+		filtered = append(filtered, key)
+		return !strings.HasPrefix(key, "x-internal")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// grpc-status and grpc-message are never passed to the filter
+	assert.ElementsMatch(t, []string{"x-internal", "x-internal-late", "x-public"}, filtered)
+
+	expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-message: ok\r\ngrpc-status: 0\r\nx-public: value\r\n")...)
+	assert.Equal(t, expected, rec.Body.Bytes())
+}
+
+func TestTrailerMechanisms(t *testing.T) {
+	for name, test := range map[string]struct {
+		handler  http.HandlerFunc
+		expected string
+	}{
+		"declared": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.Header().Add("Trailer", "Grpc-Status")
+				resp.Header().Add("Trailer", "x-declared")
+				resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+				resp.Header().Set("Grpc-Status", "0")
+				resp.Header().Set("X-Declared", "a")
+			},
+			"grpc-status: 0\r\nx-declared: a\r\n",
+		},
+		"prefixed": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+				resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+				resp.Header().Add(http.TrailerPrefix+"X-Late", "a")
+				resp.Header().Add(http.TrailerPrefix+"X-Late", "b")
+			},
+			"grpc-status: 0\r\nx-late: a\r\nx-late: b\r\n",
+		},
+		"both": {
+			func(resp http.ResponseWriter, req *http.Request) {
+				resp.Header().Set("Trailer", "Grpc-Status, X-Declared")
+				resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+				resp.Header().Set("Grpc-Status", "0")
+				resp.Header().Set("X-Declared", "a")
+				resp.Header().Set(http.TrailerPrefix+"X-Late", "b")
+			},
+			"grpc-status: 0\r\nx-declared: a\r\nx-late: b\r\n",
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(test.handler).ServeHTTP(rec, req)
+
+		expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame(test.expected)...)
+		assert.Equal(t, expected, rec.Body.Bytes(), name)
+	}
+}
+
+type cancelServer struct {
+	testpb.UnimplementedTestServiceServer
+	done chan struct{}
+}
+
+func (s cancelServer) StreamingOutputCall(_ *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	if err := stream.Send(&testpb.StreamingOutputCallResponse{}); err != nil {
+		return err
+	}
+
+	<-stream.Context().Done()
+	close(s.done)
+
+	return stream.Context().Err()
+}
+
+func TestClientDisconnect(t *testing.T) {
+	server := grpc.NewServer()
+	done := make(chan struct{})
+	testpb.RegisterTestServiceServer(server, cancelServer{done: done})
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/StreamingOutputCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+	assert.NoError(t, err)
+	req = req.WithContext(ctx)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// wait for the first message, so that the call is in progress
+	msg := make([]byte, grpcweb.FrameHeaderLength)
+	_, err = io.ReadFull(resp.Body, msg)
+	assert.NoError(t, err)
+
+	// the call is cancelled once the client goes away
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler's context wasn't cancelled after the client disconnected")
+	}
+}
+
+func TestClientAbort(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	var reported error
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-req.Context().Done()
+		close(cancelled)
+	}), grpcweb.WithErrorHandler(func(req *http.Request, err error) {
+		reported = err
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/UnaryCall", nil).WithContext(ctx)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	rec := httptest.NewRecorder()
+
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, req)
+		close(served)
+	}()
+
+	// the client aborts the call whilst the handler is working
+	<-started
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler's context wasn't cancelled after the client aborted")
+	}
+	<-served
+
+	// nobody's reading, so no trailers are written
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, context.Canceled, reported)
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/grpc.testing.TestService/StreamingOutputCall" {
+			resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+			resp.(http.Flusher).Flush()
+		}
+
+		select {
+		case <-req.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}
+	}), grpcweb.WithHeaderTimeout(10*time.Millisecond))
+
+	for method, expected := range map[string][]byte{
+		// stuck backends are timed out
+		"/grpc.testing.TestService/EmptyCall": trailerFrame("grpc-message: no response within the header timeout of 10ms\r\ngrpc-status: 4\r\n"),
+
+		// but streams that have responded run for as long as they need
+		"/grpc.testing.TestService/StreamingOutputCall": trailerFrame("grpc-status: 0\r\n"),
+	} {
+		req := httptest.NewRequest("POST", method, nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Body.Bytes(), method)
+	}
+}
+
+func TestMaxResponseBytes(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server, grpcweb.WithMaxResponseBytes(40)))
+	defer ts.Close()
+
+	// each response is a frame of 19 bytes, so only two are within the limit,
+	// whether or not the response is base64 encoded
+	for _, text := range []bool{false, true} {
+		client := webClient{client: ts.Client(), url: ts.URL, text: text}
+
+		msgs, trailers, err := client.call("/grpc.testing.TestService/StreamingOutputCall", &testpb.StreamingOutputCallRequest{
+			ResponseParameters: []*testpb.ResponseParameters{{Size: 10}, {Size: 10}, {Size: 10}},
+		})
+		assert.NoError(t, err)
+		assert.Len(t, msgs, 2)
+		assert.Equal(t, "8", trailers.Get("grpc-status"))
+		assert.Equal(t, "response exceeds the maximum of 40 bytes", trailers.Get("grpc-message"))
+	}
+
+	// frames written in pieces are only written if they're within the limit
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		for _, p := range [][]byte{{0x00, 0x00}, {0x00, 0x00, 0x02, 0x01}, {0x01}, {0x00, 0x00}, {0x00, 0x00, 0x01, 0x01}} {
+			resp.Write(p)
+		}
+	}), grpcweb.WithMaxResponseBytes(12))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x01, 0x01}, trailerFrame("grpc-message: response exceeds the maximum of 12 bytes\r\ngrpc-status: 8\r\n")...)
+	assert.Equal(t, expected, rec.Body.Bytes())
+}
+
+// unflushableWriter is a http.ResponseWriter that isn't a http.Flusher.
+type unflushableWriter struct {
+	http.ResponseWriter
+}
+
+func TestRequireFlusher(t *testing.T) {
+	msg := []byte{0, 0, 0, 0, 2, 8, 1}
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		resp.Write(msg)
+		resp.Write(msg)
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	for name, test := range map[string]struct {
+		opts     []grpcweb.Option
+		err      error
+		expected []byte
+	}{
+		"held": {
+			nil,
+			nil,
+			append(append(append([]byte{}, msg...), msg...), trailerFrame("grpc-status: 0\r\n")...),
+		},
+		"required": {
+			[]grpcweb.Option{grpcweb.WithRequireFlusher()},
+			grpcweb.ErrNotFlushable,
+			trailerFrame("grpc-message: streamed response can't be flushed\r\ngrpc-status: 13\r\n"),
+		},
+	} {
+		logger := &testLogger{}
+		var reported error
+		opts := append([]grpcweb.Option{
+			grpcweb.WithLogger(logger),
+			grpcweb.WithErrorHandler(func(req *http.Request, err error) {
+				reported = err
+			}),
+		}, test.opts...)
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, opts...).ServeHTTP(unflushableWriter{rec}, req)
+
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+		assert.Equal(t, test.err, reported, name)
+		assert.Equal(t, []string{
+			"grpcweb: /grpc.testing.TestService/StreamingOutputCall (application/grpc-web): grpcweb_test.unflushableWriter isn't a http.Flusher, messages are held until the response completes",
+		}, logger.errors, name)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server)
+	for _, test := range []struct {
+		contentType string
+		text        bool
+	}{
+		{grpcweb.ContentTypeGRPCWeb, false},
+		{grpcweb.ContentTypeGRPCWebProto, false},
+		{grpcweb.ContentTypeGRPCWebText, true},
+		{grpcweb.ContentTypeGRPCWebTextProto, true},
+	} {
+		frame := []byte{0x00, 0x00, 0x00, 0x00, 0x00}
+		expected := append(frame, trailerFrame("grpc-status: 0\r\n")...)
+
+		body := frame
+		if test.text {
+			body = []byte(base64.StdEncoding.EncodeToString(frame))
+		}
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewReader(body))
+		req.Header.Set("content-type", test.contentType)
+		assert.True(t, grpcweb.IsGRPCWebRequest(req), test.contentType)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// the response is framed and encoded the same way as the request
+		assert.Equal(t, test.contentType, rec.Header().Get("content-type"))
+		if test.text {
+			assert.Equal(t, expected, decodeSegments(t, rec.Body.String()), test.contentType)
+		} else {
+			assert.Equal(t, expected, rec.Body.Bytes(), test.contentType)
+		}
+	}
+}
+
+func TestMissingStatus(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01})
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// a handler that completes without a status can't be assumed to have
+	// succeeded
+	expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01}, trailerFrame("grpc-message: handler responded without a grpc-status\r\ngrpc-status: 2\r\n")...)
+	assert.Equal(t, expected, rec.Body.Bytes())
+}
+
+func TestPathRewrite(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server,
+		grpcweb.WithPathRewrite(func(path string) string {
+			return strings.TrimPrefix(path, "/api")
+		}),
+		grpcweb.WithKnownMethods([]string{"/grpc.testing.TestService/EmptyCall"}),
+	)
+
+	for path, expected := range map[string]string{
+		"/api/grpc.testing.TestService/EmptyCall": "grpc-status: 0\r\n",
+		"/grpc.testing.TestService/EmptyCall":     "grpc-status: 0\r\n",
+		"/api/grpc.testing.TestService/UnaryCall": "grpc-message: unknown method /grpc.testing.TestService/UnaryCall\r\ngrpc-status: 12\r\n",
+	} {
+		req := httptest.NewRequest("POST", path, bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		frame := trailerFrame(expected)
+		assert.Equal(t, frame, rec.Body.Bytes()[rec.Body.Len()-len(frame):], path)
+	}
+}
+
+func TestJSONContentTypes(t *testing.T) {
+	var forwarded string
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Get("content-type")
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x02, '{', '}'})
+	}))
+
+	for _, test := range []struct {
+		contentType string
+		accept      string
+		expected    string
+	}{
+		{grpcweb.ContentTypeGRPCWebJSON, "", grpcweb.ContentTypeGRPCWebJSON},
+		{grpcweb.ContentTypeGRPCWebTextJSON, "", grpcweb.ContentTypeGRPCWebTextJSON},
+		{grpcweb.ContentTypeGRPCWebJSON, grpcweb.ContentTypeGRPCWebTextJSON, grpcweb.ContentTypeGRPCWebTextJSON},
+	} {
+		body := "\x00\x00\x00\x00\x02{}"
+		if strings.HasPrefix(test.contentType, grpcweb.ContentTypeGRPCWebText) {
+			body = base64.StdEncoding.EncodeToString([]byte(body))
+		}
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader(body))
+		req.Header.Set("content-type", test.contentType)
+		req.Header.Set("accept", test.accept)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// the handler is left to decode the JSON messages with its codec
+		assert.Equal(t, "application/grpc+json", forwarded, test.contentType)
+		assert.Equal(t, test.expected, rec.Header().Get("content-type"), test.contentType)
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	var acceptEncoding string
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		acceptEncoding = req.Header.Get("grpc-accept-encoding")
+	}))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "identity,gzip", acceptEncoding)
+}
+
+func TestMaxTrailerBytes(t *testing.T) {
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Large")
+		resp.Header().Set("Grpc-Status", "0")
+		resp.Header().Set("Grpc-Message", "ok")
+		resp.Header().Set("X-Large", strings.Repeat("x", 100))
+	})
+
+	for name, test := range map[string]struct {
+		max      int
+		expected []byte
+		errors   int
+	}{
+		"unlimited": {0, trailerFrame("grpc-message: ok\r\ngrpc-status: 0\r\nx-large: " + strings.Repeat("x", 100) + "\r\n"), 0},
+		"within":    {200, trailerFrame("grpc-message: ok\r\ngrpc-status: 0\r\nx-large: " + strings.Repeat("x", 100) + "\r\n"), 0},
+		"exceeded":  {64, trailerFrame("grpc-message: ok\r\ngrpc-status: 0\r\n"), 1},
+	} {
+		logger := &testLogger{}
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, grpcweb.WithMaxTrailerBytes(test.max), grpcweb.WithLogger(logger)).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+		assert.Len(t, logger.errors, test.errors, name)
+	}
+}
+
+func TestMethodFilter(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server, grpcweb.WithMethodFilter(func(method string) bool {
+		return method == "/grpc.testing.TestService/EmptyCall"
+	}))
+
+	for path, expected := range map[string][]byte{
+		"/grpc.testing.TestService/EmptyCall": append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-status: 0\r\n")...),
+		"/grpc.testing.TestService/UnaryCall": trailerFrame("grpc-message: method /grpc.testing.TestService/UnaryCall isn't available to gRPC-Web clients\r\ngrpc-status: 7\r\n"),
+	} {
+		req := httptest.NewRequest("POST", path, bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, expected, rec.Body.Bytes(), path)
+	}
+
+	// native gRPC requests aren't filtered
+	ts := grpcwebtest.NewServer(server, grpcweb.WithMethodFilter(func(string) bool { return false }))
+	defer ts.Close()
+
+	conn, err := grpc.Dial(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,112 @@
+package grpcwebtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/saracen/grpcweb"
+)
+
+// EncodeTextRequest returns the body of a gRPC-Web text request carrying the
+// messages provided, as a browser client sends it: each message is framed
+// with an uncompressed frame header, and the frames are base64 encoded
+// together.
+func EncodeTextRequest(messages ...[]byte) []byte {
+	var data []byte
+	for _, msg := range messages {
+		header := make([]byte, grpcweb.FrameHeaderLength)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+		data = append(append(data, header...), msg...)
+	}
+
+	body := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(body, data)
+
+	return body
+}
+
+// DecodeTextResponse returns the messages and trailers of the body of a
+// gRPC-Web text response, which may be made up of several independently
+// padded base64 segments. The trailers must be in the response's last frame.
+func DecodeTextResponse(body []byte) ([][]byte, http.Header, error) {
+	data, err := DecodeText(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return DecodeResponse(data)
+}
+
+// DecodeText returns the frames of the body of a gRPC-Web text response,
+// base64 decoding each of its independently padded segments.
+func DecodeText(body []byte) ([]byte, error) {
+	text := strings.NewReplacer("\r", "", "\n", "").Replace(string(body))
+
+	var data []byte
+	for text != "" {
+		// a segment ends after its padding, or at the end of the response
+		end := len(text)
+		if i := strings.IndexByte(text, '='); i >= 0 {
+			end = i
+			for end < len(text) && text[end] == '=' {
+				end++
+			}
+		}
+
+		segment, err := base64.StdEncoding.DecodeString(text[:end])
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, segment...)
+		text = text[end:]
+	}
+
+	return data, nil
+}
+
+// DecodeResponse returns the messages and trailers of the body of a binary
+// gRPC-Web response. The trailers must be in the response's last frame.
+func DecodeResponse(data []byte) ([][]byte, http.Header, error) {
+	var msgs [][]byte
+	for len(data) > 0 {
+		if len(data) < grpcweb.FrameHeaderLength {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+
+		flags := data[0]
+		length := int(binary.BigEndian.Uint32(data[1:grpcweb.FrameHeaderLength]))
+		data = data[grpcweb.FrameHeaderLength:]
+		if len(data) < length {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+
+		payload := data[:length]
+		data = data[length:]
+
+		if flags&grpcweb.TrailerFrameFlag == 0 {
+			msgs = append(msgs, payload)
+			continue
+		}
+		if len(data) > 0 {
+			return nil, nil, fmt.Errorf("%d bytes after trailer frame", len(data))
+		}
+
+		r := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(payload), strings.NewReader("\r\n"))))
+		trailers, err := r.ReadMIMEHeader()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return msgs, http.Header(trailers), nil
+	}
+
+	return nil, nil, io.ErrUnexpectedEOF
+}
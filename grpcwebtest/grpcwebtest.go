@@ -0,0 +1,22 @@
+// Package grpcwebtest provides a server for testing gRPC servers with gRPC-Web
+// clients, and native gRPC clients, without TLS.
+package grpcwebtest
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/saracen/grpcweb"
+)
+
+// NewServer starts and returns a plaintext HTTP server, serving gRPC-Web
+// requests over HTTP/1.x and native gRPC requests over HTTP/2 cleartext (h2c)
+// with the gRPC handler provided, such as a *grpc.Server. Other requests are
+// responded to with 404 Not Found. The caller should call Close when
+// finished, to shut it down.
+//
+// Native gRPC clients connect to the server's Listener.Addr() with insecure
+// transport credentials.
+func NewServer(gRPCHandler http.Handler, opts ...grpcweb.Option) *httptest.Server {
+	return httptest.NewServer(grpcweb.H2CHandler(gRPCHandler, http.NotFoundHandler(), opts...))
+}
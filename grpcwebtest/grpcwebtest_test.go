@@ -0,0 +1,79 @@
+package grpcwebtest_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestNewServer(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := grpcwebtest.NewServer(server)
+	defer ts.Close()
+
+	// native gRPC
+	conn, err := grpc.Dial(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = testpb.NewTestServiceClient(conn).EmptyCall(context.Background(), &testpb.Empty{})
+	assert.NoError(t, err)
+
+	// gRPC-Web
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	expected := append([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10}, "grpc-status: 0\r\n"...)
+	assert.Equal(t, expected, data)
+
+	// everything else
+	resp, err = ts.Client().Get(ts.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestTextEncoding(t *testing.T) {
+	assert.Equal(t, "AAAAAAQQBSAB", string(grpcwebtest.EncodeTextRequest([]byte{0x10, 0x05, 0x20, 0x01})))
+
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := grpcwebtest.NewServer(server)
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/UnaryCall", bytes.NewReader(grpcwebtest.EncodeTextRequest([]byte{0x10, 0x05, 0x20, 0x01})))
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	msgs, trailers, err := grpcwebtest.DecodeTextResponse(data)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, "0", trailers.Get("grpc-status"))
+}
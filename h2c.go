@@ -0,0 +1,21 @@
+package grpcweb
+
+import (
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// H2CHandler returns a RootHandler that also accepts HTTP/2 cleartext (h2c)
+// connections, so that native gRPC clients can connect without TLS alongside
+// gRPC-Web and regular HTTP/1.x clients, all served by one http.Server. This
+// is intended for use behind a load balancer that terminates TLS.
+//
+// As with RootHandler, native gRPC requests are served by the gRPC handler's
+// ServeHTTP method. Read
+// https://godoc.org/google.golang.org/grpc#Server.ServeHTTP for the
+// performance implications of this.
+func H2CHandler(gRPCHandler http.Handler, fallback http.Handler, opts ...Option) http.Handler {
+	return h2c.NewHandler(RootHandler(gRPCHandler, fallback, opts...), &http2.Server{})
+}
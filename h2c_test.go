@@ -0,0 +1,58 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestH2CHandler(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	fallback := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("fallback"))
+	})
+
+	ts := httptest.NewServer(grpcweb.H2CHandler(server, fallback))
+	defer ts.Close()
+
+	// native gRPC over h2c
+	conn, err := grpc.Dial(strings.TrimPrefix(ts.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = testpb.NewTestServiceClient(conn).EmptyCall(context.Background(), &testpb.Empty{})
+	assert.NoError(t, err)
+
+	// gRPC-Web over HTTP/1.1
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame("grpc-status: 0\r\n")...), data)
+
+	// regular HTTP/1.1
+	resp, err = ts.Client().Get(ts.URL)
+	assert.NoError(t, err)
+	data, err = ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "fallback", string(data))
+}
@@ -0,0 +1,90 @@
+package grpcweb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// healthCheckTimeout is the longest a health check can take before the
+// backend is considered unavailable.
+const healthCheckTimeout = time.Second
+
+// healthChecker caches the result of a backend's health check. The first
+// check is made by the first request, and later checks in the background by
+// whichever request first finds the cached result stale, whilst requests
+// continue to use the previous result. Checks aren't tied to any request, so
+// that a request that's canceled can't fail them.
+type healthChecker struct {
+	client   healthpb.HealthClient
+	service  string
+	interval time.Duration
+
+	mu       sync.Mutex
+	serving  bool
+	checked  time.Time
+	checking bool
+}
+
+func (hc *healthChecker) isServing() bool {
+	hc.mu.Lock()
+	if hc.checking || time.Since(hc.checked) < hc.interval {
+		defer hc.mu.Unlock()
+		return hc.serving
+	}
+	hc.checking = true
+	first := hc.checked.IsZero()
+	serving := hc.serving
+	hc.mu.Unlock()
+
+	if first {
+		return hc.check()
+	}
+
+	go hc.check()
+	return serving
+}
+
+// check checks the health of the backend and caches the result.
+func (hc *healthChecker) check() bool {
+	timeout := healthCheckTimeout
+	if hc.interval > 0 && hc.interval < timeout {
+		timeout = hc.interval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := hc.client.Check(ctx, &healthpb.HealthCheckRequest{Service: hc.service})
+	serving := err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	// a canceled check says nothing about the backend, so the previous
+	// result is kept, and the next request checks again
+	if status.Code(err) == codes.Canceled {
+		hc.checking = false
+		return hc.serving
+	}
+
+	hc.serving = serving
+	hc.checked = time.Now()
+	hc.checking = false
+
+	return serving
+}
+
+// retryAfter returns the Retry-After value in seconds, rounded up.
+func (hc *healthChecker) retryAfter() int {
+	secs := int((hc.interval + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+
+	return secs
+}
@@ -0,0 +1,113 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestBackendHealthCheck(t *testing.T) {
+	healthServer := health.NewServer()
+
+	backend := grpc.NewServer()
+	testpb.RegisterTestServiceServer(backend, interop.NewTestServer())
+	healthpb.RegisterHealthServer(backend, healthServer)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go backend.Serve(lis)
+	defer backend.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	tests := []struct {
+		status     healthpb.HealthCheckResponse_ServingStatus
+		code       int
+		retryAfter string
+		response   []byte
+	}{
+		{
+			healthpb.HealthCheckResponse_NOT_SERVING,
+			http.StatusServiceUnavailable,
+			"60",
+			trailerFrame("grpc-message: backend unavailable\r\ngrpc-status: 14\r\n"),
+		},
+		{
+			healthpb.HealthCheckResponse_SERVING,
+			http.StatusOK,
+			"",
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x80, 0x00, 0x00, 0x00, 0x10, 0x67, 0x72, 0x70, 0x63, 0x2d, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x3a, 0x20, 0x30, 0x0d, 0x0a},
+		},
+	}
+
+	for _, test := range tests {
+		healthServer.SetServingStatus("", test.status)
+
+		ts := httptest.NewServer(grpcweb.Handler(backend, grpcweb.WithBackendHealthCheck(healthpb.NewHealthClient(conn), "", time.Minute)))
+
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, test.code, resp.StatusCode)
+		assert.Equal(t, test.retryAfter, resp.Header.Get("retry-after"))
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, test.response, data)
+
+		ts.Close()
+	}
+}
+
+// requestContextHealthClient fails health checks whose context is done.
+type requestContextHealthClient struct {
+	healthpb.HealthClient
+}
+
+func (requestContextHealthClient) Check(ctx context.Context, in *healthpb.HealthCheckRequest, opts ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func TestBackendHealthCheckCanceledRequest(t *testing.T) {
+	backend := grpc.NewServer()
+	testpb.RegisterTestServiceServer(backend, interop.NewTestServer())
+
+	handler := grpcweb.Handler(backend, grpcweb.WithBackendHealthCheck(requestContextHealthClient{}, "", time.Minute))
+
+	// a canceled request doesn't fail the check for later requests
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, ctx := range []context.Context{ctx, context.Background()} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+
+		assert.NotEqual(t, http.StatusServiceUnavailable, rec.Code)
+	}
+}
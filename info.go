@@ -0,0 +1,56 @@
+package grpcweb
+
+import "context"
+
+// RequestInfo describes how a gRPC-Web request was negotiated.
+type RequestInfo struct {
+	// Method is the full gRPC method name ("/package.Service/Method").
+	Method string
+
+	// ContentType is the content-type of the original gRPC-Web request.
+	ContentType string
+
+	// TextRequest is true if the request body was base64 encoded.
+	TextRequest bool
+
+	// TextResponse is true if the response body is base64 encoded.
+	TextResponse bool
+
+	// RequestID is the ID the request is correlated by, when enabled with
+	// WithRequestIDHeader.
+	RequestID string
+
+	// The bytes of the request and response bodies, as sent over the wire
+	// and once base64 decoded, or before being encoded. They differ only for
+	// text requests and responses. They're only known once the request has
+	// completed, and so are only set in the RequestInfo passed to an
+	// observer added with WithRequestObserver.
+	WireBytesIn     int64
+	WireBytesOut    int64
+	DecodedBytesIn  int64
+	DecodedBytesOut int64
+
+	// Incomplete is true if the response couldn't be completely written,
+	// usually as the client disconnected. Like the byte counts, it's only
+	// set in the RequestInfo passed to an observer.
+	Incomplete bool
+}
+
+type requestInfoKey struct{}
+
+// WithRequestObserver calls fn with the RequestInfo of each gRPC-Web request
+// once it has completed, including the bytes read and written.
+func WithRequestObserver(fn func(RequestInfo)) Option {
+	return func(o *options) {
+		o.observer = fn
+	}
+}
+
+// RequestInfoFromContext returns the RequestInfo of the gRPC-Web request the
+// context belongs to. The context of a request passed to the wrapped handler
+// has one, as do the contexts gRPC server handlers and interceptors are
+// called with.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
@@ -0,0 +1,133 @@
+package grpcweb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestRequestInfo(t *testing.T) {
+	var infos []grpcweb.RequestInfo
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		requestInfo, ok := grpcweb.RequestInfoFromContext(ctx)
+		assert.True(t, ok)
+		infos = append(infos, requestInfo)
+
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server)
+	for _, test := range []struct {
+		contentType string
+		accept      string
+		body        string
+	}{
+		{grpcweb.ContentTypeGRPCWebText, grpcweb.ContentTypeGRPCWeb, "AAAAAAA="},
+		{grpcweb.ContentTypeGRPCWebProto, "", "\x00\x00\x00\x00\x00"},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader(test.body))
+		req.Header.Set("content-type", test.contentType)
+		req.Header.Set("accept", test.accept)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []grpcweb.RequestInfo{
+		{Method: "/grpc.testing.TestService/EmptyCall", ContentType: grpcweb.ContentTypeGRPCWebText, TextRequest: true, TextResponse: false},
+		{Method: "/grpc.testing.TestService/EmptyCall", ContentType: grpcweb.ContentTypeGRPCWebProto, TextRequest: false, TextResponse: false},
+	}, infos)
+
+	_, ok := grpcweb.RequestInfoFromContext(httptest.NewRequest("GET", "/", nil).Context())
+	assert.False(t, ok)
+}
+
+func TestRequestObserver(t *testing.T) {
+	var infos []grpcweb.RequestInfo
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}), grpcweb.WithRequestObserver(func(info grpcweb.RequestInfo) {
+		infos = append(infos, info)
+	}))
+
+	for _, contentType := range []string{grpcweb.ContentTypeGRPCWebText, grpcweb.ContentTypeGRPCWeb} {
+		body := "\x00\x00\x00\x00\x00"
+		if contentType == grpcweb.ContentTypeGRPCWebText {
+			body = "AAAAAAA="
+		}
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader(body))
+		req.Header.Set("content-type", contentType)
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// the response is a message frame of 5 bytes and a trailer frame of 21
+	// bytes, each base64 encoded separately in text mode
+	assert.Equal(t, []grpcweb.RequestInfo{
+		{
+			Method: "/grpc.testing.TestService/EmptyCall", ContentType: grpcweb.ContentTypeGRPCWebText, TextRequest: true, TextResponse: true,
+			WireBytesIn: 8, WireBytesOut: 36, DecodedBytesIn: 5, DecodedBytesOut: 26,
+		},
+		{
+			Method: "/grpc.testing.TestService/EmptyCall", ContentType: grpcweb.ContentTypeGRPCWeb,
+			WireBytesIn: 5, WireBytesOut: 26, DecodedBytesIn: 5, DecodedBytesOut: 26,
+		},
+	}, infos)
+}
+
+func TestRequestObserverIncomplete(t *testing.T) {
+	infos := make(chan grpcweb.RequestInfo, 1)
+	ts := httptest.NewServer(grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+
+		// the stream continues until the client has gone
+		for {
+			if _, err := resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
+				return
+			}
+			resp.(http.Flusher).Flush()
+
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}), grpcweb.WithRequestObserver(func(info grpcweb.RequestInfo) {
+		infos <- info
+	})))
+	defer ts.Close()
+
+	req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/StreamingOutputCall", nil)
+	assert.NoError(t, err)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	resp, err := ts.Client().Do(req)
+	assert.NoError(t, err)
+
+	// the client disconnects mid-stream
+	_, err = resp.Body.Read(make([]byte, 5))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	select {
+	case info := <-infos:
+		assert.True(t, info.Incomplete)
+	case <-time.After(5 * time.Second):
+		t.Fatal("observer not called after the client disconnected")
+	}
+}
@@ -0,0 +1,164 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+// webClient is a minimal gRPC-Web client, making calls the way a browser
+// client does.
+type webClient struct {
+	client *http.Client
+	url    string
+	text   bool
+}
+
+// call makes a call of the method with the request message provided,
+// returning the response messages and trailers.
+func (c webClient) call(method string, in proto.Message) ([][]byte, http.Header, error) {
+	msg, err := proto.Marshal(in)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body := make([]byte, grpcweb.FrameHeaderLength, grpcweb.FrameHeaderLength+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	contentType := grpcweb.ContentTypeGRPCWeb
+	if c.text {
+		contentType = grpcweb.ContentTypeGRPCWebText
+		body = []byte(base64.StdEncoding.EncodeToString(body))
+	}
+
+	req, err := http.NewRequest("POST", c.url+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("content-type", contentType)
+	req.Header.Set("accept", contentType)
+	req.Header.Set("x-grpc-web", "1")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("content-type"); got != contentType {
+		return nil, nil, fmt.Errorf("unexpected content-type %q", got)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.text {
+		return grpcwebtest.DecodeTextResponse(data)
+	}
+
+	return grpcwebtest.DecodeResponse(data)
+}
+
+func TestIntegration(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	ts := httptest.NewServer(grpcweb.Handler(server))
+	defer ts.Close()
+
+	for _, text := range []bool{false, true} {
+		client := webClient{client: ts.Client(), url: ts.URL, text: text}
+
+		msgs, trailers, err := client.call("/grpc.testing.TestService/UnaryCall", &testpb.SimpleRequest{
+			ResponseSize: 3,
+			Payload:      &testpb.Payload{Body: []byte{0x01}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "0", trailers.Get("grpc-status"))
+
+		if assert.Len(t, msgs, 1) {
+			var resp testpb.SimpleResponse
+			assert.NoError(t, proto.Unmarshal(msgs[0], &resp))
+			assert.Len(t, resp.GetPayload().GetBody(), 3)
+		}
+
+		msgs, trailers, err = client.call("/grpc.testing.TestService/StreamingOutputCall", &testpb.StreamingOutputCallRequest{
+			ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "0", trailers.Get("grpc-status"))
+
+		if assert.Len(t, msgs, 3) {
+			for i, msg := range msgs {
+				var resp testpb.StreamingOutputCallResponse
+				assert.NoError(t, proto.Unmarshal(msg, &resp))
+				assert.Len(t, resp.GetPayload().GetBody(), i+1)
+			}
+		}
+
+		_, trailers, err = client.call("/grpc.testing.TestService/UnimplementedCall", &testpb.Empty{})
+		assert.NoError(t, err)
+		assert.Equal(t, "12", trailers.Get("grpc-status"))
+	}
+}
+
+func TestLargeUnaryTextResponse(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	for name, handler := range map[string]http.Handler{
+		"streamed": grpcweb.Handler(server),
+		"buffered": grpcweb.HandlerForServer(server),
+	} {
+		ts := httptest.NewServer(handler)
+
+		req, err := http.NewRequest("POST", ts.URL+"/grpc.testing.TestService/UnaryCall", strings.NewReader(base64.StdEncoding.EncodeToString([]byte{0x00, 0x00, 0x00, 0x00, 0x04, 0x10, 0x80, 0x80, 0x40})))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		ts.Close()
+
+		// the message, a SimpleResponse with a 1MiB payload, is a single
+		// base64 blob, however many writes it took, followed by the trailers
+		trailer := base64.StdEncoding.EncodeToString(trailerFrame("grpc-status: 0\r\n"))
+		if !assert.True(t, strings.HasSuffix(string(data), trailer), name) {
+			continue
+		}
+
+		msg, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(string(data), trailer))
+		assert.NoError(t, err, name)
+
+		msgs, _, err := grpcwebtest.DecodeResponse(append(msg, trailerFrame("grpc-status: 0\r\n")...))
+		assert.NoError(t, err, name)
+		if assert.Len(t, msgs, 1, name) {
+			var resp testpb.SimpleResponse
+			assert.NoError(t, proto.Unmarshal(msgs[0], &resp), name)
+			assert.Len(t, resp.GetPayload().GetBody(), 1<<20, name)
+		}
+	}
+}
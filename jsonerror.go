@@ -0,0 +1,54 @@
+package grpcweb
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// contentTypeJSON is the content-type of JSON errors, which clients accept to
+// have errors responded to with one with WithJSONErrors.
+const contentTypeJSON = "application/json"
+
+// jsonError is the body of a JSON error.
+type jsonError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// WithJSONErrors responds to unary gRPC-Web requests whose Accept header
+// includes application/json, and that fail, with a JSON body such as
+// {"code":5,"message":"not found"} and an application/json content-type,
+// rather than a trailer frame, for clients that aren't using a gRPC-Web client
+// library. The response is still HTTP 200, and successful responses are
+// unaffected.
+//
+// Only responses to methods known to be unary, such as those registered with
+// the server passed to HandlerForServer, are buffered so that they can be
+// replaced by a JSON error.
+func WithJSONErrors() Option {
+	return func(o *options) {
+		o.jsonErrors = true
+	}
+}
+
+// writeJSONError replaces the buffered response with a JSON error.
+func (w *gRPCWebResponseWriter) writeJSONError(code codes.Code, msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.setHeaders()
+	w.discardBuffer()
+
+	header := w.wrapped.Header()
+	header.Set(headerContentType, contentTypeJSON)
+	header.Del(headerContentLength)
+	w.wrapped.WriteHeader(http.StatusOK)
+
+	body, _ := json.Marshal(jsonError{Code: int(code), Message: msg})
+	if _, err := (wireWriter{w}).Write(body); err != nil {
+		w.log.errorf("writing JSON error: %v", err)
+		w.reportError(err)
+	}
+}
@@ -0,0 +1,78 @@
+package grpcweb_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+)
+
+type jsonErrorServer struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (jsonErrorServer) EmptyCall(ctx context.Context, _ *testpb.Empty) (*testpb.Empty, error) {
+	return &testpb.Empty{}, nil
+}
+
+func (jsonErrorServer) UnaryCall(ctx context.Context, _ *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return nil, status.Error(codes.NotFound, "no such thing")
+}
+
+func TestJSONErrors(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, jsonErrorServer{})
+	handler := grpcweb.HandlerForServer(server, grpcweb.WithJSONErrors())
+
+	for name, test := range map[string]struct {
+		path        string
+		accept      string
+		contentType string
+		json        string
+	}{
+		"error": {
+			"/grpc.testing.TestService/UnaryCall",
+			"application/grpc-web, application/json",
+			"application/json",
+			`{"code":5,"message":"no such thing"}`,
+		},
+		"not accepted": {
+			"/grpc.testing.TestService/UnaryCall",
+			grpcweb.ContentTypeGRPCWeb,
+			grpcweb.ContentTypeGRPCWeb,
+			"",
+		},
+		"success": {
+			"/grpc.testing.TestService/EmptyCall",
+			"application/grpc-web, application/json",
+			grpcweb.ContentTypeGRPCWeb,
+			"",
+		},
+	} {
+		req := httptest.NewRequest("POST", test.path, strings.NewReader("\x00\x00\x00\x00\x00"))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("accept", test.accept)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, 200, rec.Code, name)
+		assert.Equal(t, test.contentType, rec.Header().Get("content-type"), name)
+		if test.json != "" {
+			assert.JSONEq(t, test.json, rec.Body.String(), name)
+			continue
+		}
+
+		_, trailers, err := grpcwebtest.DecodeResponse(rec.Body.Bytes())
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, trailers.Get("grpc-status"), name)
+	}
+}
@@ -0,0 +1,126 @@
+package grpcweb
+
+import (
+	"sync"
+	"time"
+)
+
+// WithKeepAlive writes an empty message frame to server streams that have
+// been idle for the interval provided, so that proxies and browsers that time
+// out idle connections don't close long-lived streams. Keepalives stop as soon
+// as the handler writes, and are only written once it has flushed a complete
+// message frame, so they never split one. Keepalives count towards
+// WithMaxResponseBytes, and stop rather than exceed it. Clients receive
+// keepalives as empty messages, so it's only suitable for streams whose
+// clients can tell them apart, such as those that never send an empty message
+// otherwise. Unary responses, which are buffered, are never kept alive.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(o *options) {
+		o.keepAlive = interval
+	}
+}
+
+// keepAliveFrame is the frame of an empty message.
+var keepAliveFrame = []byte{0x00, 0x00, 0x00, 0x00, 0x00}
+
+// keepAliveWriter serializes the handler's writes with the keepalives written
+// to the response while it's idle.
+type keepAliveWriter struct {
+	*gRPCWebResponseWriter
+
+	mu sync.Mutex
+	// active is set when the handler has written since the last tick, and
+	// flushed is set when what it last did was flush a complete message
+	active  bool
+	flushed bool
+
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// startKeepAlive writes keepalives to w every interval that the handler
+// hasn't written, until stop is called.
+func startKeepAlive(w *gRPCWebResponseWriter, interval time.Duration) *keepAliveWriter {
+	k := &keepAliveWriter{
+		gRPCWebResponseWriter: w,
+		stopped:               make(chan struct{}),
+		done:                  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(k.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				k.tick()
+			case <-k.stopped:
+				return
+			}
+		}
+	}()
+
+	return k
+}
+
+// stop stops the keepalives, waiting for any being written, so that the
+// trailers can be written.
+func (k *keepAliveWriter) stop() {
+	close(k.stopped)
+	<-k.done
+}
+
+func (k *keepAliveWriter) tick() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	w := k.gRPCWebResponseWriter
+	if k.active {
+		k.active = false
+		return
+	}
+
+	// nothing is written until the handler has sent the response's headers,
+	// its leading metadata, and nothing once the response has failed
+	if !k.flushed || !w.wroteHeader || w.passthrough || w.nonGRPC || w.exceeded || w.writeErr != nil {
+		return
+	}
+
+	// keepalives are written the same way as the handler's messages, so
+	// they're counted towards the response's limit and audited, but only
+	// between complete frames, and only while within the limit
+	if !w.limit.fits(int64(len(keepAliveFrame))) {
+		return
+	}
+	if _, err := w.Write(keepAliveFrame); err != nil {
+		return
+	}
+	w.Flush()
+}
+
+func (k *keepAliveWriter) Write(p []byte) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.active, k.flushed = true, false
+	return k.gRPCWebResponseWriter.Write(p)
+}
+
+func (k *keepAliveWriter) WriteHeader(statusCode int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.active, k.flushed = true, true
+	k.gRPCWebResponseWriter.WriteHeader(statusCode)
+}
+
+func (k *keepAliveWriter) Flush() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.active, k.flushed = true, true
+	k.gRPCWebResponseWriter.Flush()
+}
@@ -0,0 +1,102 @@
+package grpcweb_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/saracen/grpcweb"
+	"github.com/saracen/grpcweb/grpcwebtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepAlive(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		w.WriteHeader(http.StatusOK)
+
+		// the message's header and data are written separately, as a gRPC
+		// handler does, and aren't split by keepalives
+		for _, p := range [][]byte{{0x00, 0x00, 0x00, 0x00, 0x01}, {0x01}} {
+			w.Write(p)
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.(http.Flusher).Flush()
+
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x02})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}), grpcweb.WithKeepAlive(5*time.Millisecond))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	msgs, trailers, err := grpcwebtest.DecodeResponse(rec.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "0", trailers.Get("grpc-status"))
+
+	// keepalives are only written while the stream is idle between messages
+	if assert.True(t, len(msgs) > 2) {
+		assert.Equal(t, []byte{0x01}, msgs[0])
+		assert.Equal(t, []byte{0x02}, msgs[len(msgs)-1])
+		for _, msg := range msgs[1 : len(msgs)-1] {
+			assert.Empty(t, msg)
+		}
+	}
+}
+
+func TestKeepAlivePartialFrame(t *testing.T) {
+	msg := []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x01, 0x02, 0x03}
+
+	for name, split := range map[string]int{
+		"partial header":  3,
+		"partial message": 6,
+	} {
+		handler := grpcweb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+
+			// the handler flushes mid-frame, and then idles
+			w.Write(msg[:split])
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			w.Write(msg[split:])
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+		}), grpcweb.WithKeepAlive(5*time.Millisecond))
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// no keepalive is written into the middle of the frame
+		assert.Equal(t, append(append([]byte{}, msg...), trailerFrame("grpc-status: 0\r\n")...), rec.Body.Bytes(), name)
+	}
+}
+
+func TestKeepAliveResponseLimit(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+		w.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x01})
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}), grpcweb.WithKeepAlive(5*time.Millisecond), grpcweb.WithMaxResponseBytes(16))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// keepalives stop once another would exceed the limit, rather than fail
+	// the response
+	msgs, trailers, err := grpcwebtest.DecodeResponse(rec.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "0", trailers.Get("grpc-status"))
+	assert.Equal(t, [][]byte{{0x01}, {}, {}}, msgs)
+}
@@ -0,0 +1,28 @@
+package grpcweb
+
+// Logger logs why gRPC-Web requests fail. Messages include the request's path
+// and content-type, but never the messages sent in either direction.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+	Debugf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+// requestLogger prefixes messages with the request they're about.
+type requestLogger struct {
+	Logger
+	path        string
+	contentType string
+}
+
+func (l requestLogger) errorf(format string, args ...interface{}) {
+	l.Errorf("grpcweb: %s (%s): "+format, append([]interface{}{l.path, l.contentType}, args...)...)
+}
+
+func (l requestLogger) debugf(format string, args ...interface{}) {
+	l.Debugf("grpcweb: %s (%s): "+format, append([]interface{}{l.path, l.contentType}, args...)...)
+}
@@ -0,0 +1,92 @@
+package grpcweb_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogger struct {
+	errors []string
+	debugs []string
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+// failingWriter is a http.ResponseWriter that isn't a http.Flusher, and fails
+// every write.
+type failingWriter struct {
+	header http.Header
+}
+
+func (w *failingWriter) Header() http.Header         { return w.header }
+func (w *failingWriter) Write(p []byte) (int, error) { return 0, errors.New("connection reset") }
+func (w *failingWriter) WriteHeader(statusCode int)  {}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		resp.(http.Flusher).Flush()
+	}), grpcweb.WithLogger(logger))
+
+	req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAdzZWNyZXRz!"))
+	req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+	handler.ServeHTTP(&failingWriter{header: make(http.Header)}, req)
+
+	prefix := "grpcweb: /grpc.testing.TestService/EmptyCall (application/grpc-web-text): "
+	assert.Equal(t, []string{
+		prefix + "*grpcweb_test.failingWriter isn't a http.Flusher, response not flushed",
+		prefix + "decoding request body: invalid base64 request body",
+	}, logger.debugs)
+	assert.Equal(t, []string{prefix + "writing trailers: connection reset"}, logger.errors)
+
+	for _, msg := range append(logger.debugs, logger.errors...) {
+		assert.NotContains(t, msg, "ZWNyZXRz")
+		assert.NotContains(t, msg, "secret")
+	}
+}
+
+func TestMissingTrailersLogged(t *testing.T) {
+	for contentType, test := range map[string]struct {
+		errors   int
+		trailers string
+	}{
+		grpcweb.ContentTypeGRPC: {1, "grpc-message: gRPC response without a grpc-status trailer\r\ngrpc-status: 13\r\n"},
+		"":                      {0, "grpc-message: handler responded without a grpc-status\r\ngrpc-status: 2\r\n"},
+	} {
+		logger := &testLogger{}
+		handler := grpcweb.Handler(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			if contentType != "" {
+				resp.Header().Set("content-type", contentType)
+			}
+			resp.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		}), grpcweb.WithLogger(logger))
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// a gRPC response without a status hints at a stripped TE header,
+		// and isn't reported as a success
+		assert.Len(t, logger.errors, test.errors, contentType)
+		for _, msg := range logger.errors {
+			assert.Contains(t, msg, `"TE: trailers"`)
+		}
+		assert.Equal(t, append([]byte{0x00, 0x00, 0x00, 0x00, 0x00}, trailerFrame(test.trailers)...), rec.Body.Bytes(), contentType)
+	}
+}
@@ -0,0 +1,101 @@
+package grpcweb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// metrics counts the gRPC-Web requests served by a handler.
+type metrics struct {
+	path string
+
+	mu         sync.Mutex
+	requests   map[string]uint64
+	responses  map[string]uint64
+	incomplete map[string]uint64
+}
+
+// WithMetricsEndpoint serves counters of the gRPC-Web requests handled, the
+// gRPC status they completed with, and the responses that couldn't be
+// completely written, in the OpenMetrics text format at the path provided.
+// Only GET and HEAD requests that aren't gRPC or gRPC-Web requests are served
+// by the endpoint, so it never shadows a gRPC method.
+func WithMetricsEndpoint(path string) Option {
+	return func(o *options) {
+		o.metrics = &metrics{
+			path:       path,
+			requests:   make(map[string]uint64),
+			responses:  make(map[string]uint64),
+			incomplete: make(map[string]uint64),
+		}
+	}
+}
+
+// record counts a request of the content-type provided, the status found in
+// its trailers, and whether its response couldn't be completely written.
+func (m *metrics) record(contentType string, trailers http.Header, complete bool) {
+	if m == nil {
+		return
+	}
+
+	code := statusCode(trailers).String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[contentType]++
+	m.responses[code]++
+	if !complete {
+		m.incomplete[contentType]++
+	}
+}
+
+func (m *metrics) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set(headerContentType, contentTypeOpenMetrics)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	// the counters are copied so that a slow scrape doesn't hold up the
+	// requests recording to them
+	m.mu.Lock()
+	requests := copyCounts(m.requests)
+	responses := copyCounts(m.responses)
+	incomplete := copyCounts(m.incomplete)
+	m.mu.Unlock()
+
+	writeCounter(resp, "grpcweb_requests", "gRPC-Web requests handled, by content-type.", "content_type", requests)
+	writeCounter(resp, "grpcweb_responses", "gRPC-Web responses written, by gRPC status.", "grpc_code", responses)
+	writeCounter(resp, "grpcweb_incomplete_responses", "gRPC-Web responses that couldn't be completely written, usually as the client disconnected, by content-type.", "content_type", incomplete)
+	io.WriteString(resp, "# EOF\n")
+}
+
+func copyCounts(values map[string]uint64) map[string]uint64 {
+	counts := make(map[string]uint64, len(values))
+	for key, value := range values {
+		counts[key] = value
+	}
+
+	return counts
+}
+
+func writeCounter(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s_total{%s=%s} %d\n", name, label, strconv.Quote(key), values[key])
+	}
+}
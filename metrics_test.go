@@ -0,0 +1,145 @@
+package grpcweb_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fallback", http.StatusTeapot)
+	})
+
+	ts := httptest.NewServer(grpcweb.RootHandler(server, fallback,
+		grpcweb.WithKnownMethods([]string{"/grpc.testing.TestService/EmptyCall", "/metrics"}),
+		grpcweb.WithMetricsEndpoint("/metrics"),
+	))
+	defer ts.Close()
+
+	for path, contentType := range map[string]string{
+		"/grpc.testing.TestService/EmptyCall": grpcweb.ContentTypeGRPCWeb,
+		"/grpc.testing.TestService/Unknown":   grpcweb.ContentTypeGRPCWebProto,
+		"/metrics":                            grpcweb.ContentTypeGRPCWeb,
+	} {
+		req, err := http.NewRequest("POST", ts.URL+path, bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		assert.NoError(t, err)
+		req.Header.Set("content-type", contentType)
+
+		resp, err := ts.Client().Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		// gRPC-Web requests are never served by the endpoint
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+		assert.True(t, strings.HasPrefix(resp.Header.Get("content-type"), grpcweb.ContentTypeGRPCWeb), path)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/other")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	resp, err = ts.Client().Get(ts.URL + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("content-type"), "application/openmetrics-text"))
+
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Equal(t, "# EOF", lines[len(lines)-1])
+
+	sample := regexp.MustCompile(`^[a-z_]+_total\{[a-z_]+="[^"]*"\} [0-9]+$`)
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			assert.Regexp(t, sample, line)
+		}
+	}
+
+	assert.Contains(t, lines, `grpcweb_requests_total{content_type="application/grpc-web"} 2`)
+	assert.Contains(t, lines, `grpcweb_requests_total{content_type="application/grpc-web+proto"} 1`)
+	assert.Contains(t, lines, `grpcweb_responses_total{grpc_code="OK"} 1`)
+	assert.Contains(t, lines, `grpcweb_responses_total{grpc_code="Unimplemented"} 2`)
+}
+
+func TestIncompleteResponseMetrics(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+	}), grpcweb.WithMetricsEndpoint("/metrics"))
+
+	for _, w := range []http.ResponseWriter{httptest.NewRecorder(), &failingWriter{header: make(http.Header)}} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		handler.ServeHTTP(w, req)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	lines := strings.Split(rec.Body.String(), "\n")
+	assert.Contains(t, lines, `grpcweb_requests_total{content_type="application/grpc-web"} 2`)
+	assert.Contains(t, lines, `grpcweb_incomplete_responses_total{content_type="application/grpc-web"} 1`)
+}
+
+// blockingWriter blocks writes until unblocked, like a slow client.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.unblock
+
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestMetricsEndpointSlowScrape(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server, grpcweb.WithMetricsEndpoint("/metrics"))
+
+	scrape := &blockingWriter{httptest.NewRecorder(), make(chan struct{}), make(chan struct{})}
+	defer close(scrape.unblock)
+	go handler.ServeHTTP(scrape, httptest.NewRequest("GET", "/metrics", nil))
+	<-scrape.writing
+
+	// requests are recorded whilst the scrape is being written
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", bytes.NewReader([]byte{0x00, 0x00, 0x00, 0x00, 0x00}))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request blocked by a slow scrape")
+	}
+}
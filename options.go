@@ -0,0 +1,497 @@
+package grpcweb
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Option configures the behaviour of a gRPC-Web handler.
+type Option func(*options)
+
+type options struct {
+	knownMethods     map[string]struct{}
+	methodFilter     func(string) bool
+	unaryMethods     map[string]struct{}
+	health           *healthChecker
+	audit            *auditor
+	metrics          *metrics
+	reflection       *reflection
+	clientConfig     *clientConfig
+	inFlight         chan struct{}
+	logger           Logger
+	cors             *cors
+	preflightHandler http.Handler
+	errorHandler     func(*http.Request, error)
+	errorMapper      func(error) codes.Code
+	strictAccept     bool
+	strict           bool
+
+	requireFlusher      bool
+	continuousText      bool
+	sse                 bool
+	jsonErrors          bool
+	unencodedText       bool
+	contentLength       bool
+	noProtocolRewrite   bool
+	maxResponseBytes    int64
+	maxBufferedResponse int
+	maxFrameBytes       int64
+	keepAlive           time.Duration
+	headerTimeout       time.Duration
+	requestTimeout      time.Duration
+
+	requireGRPCWebHeader bool
+
+	bypassPrefixes []string
+	bypassHandler  http.Handler
+	grpcDetector   func(*http.Request) bool
+
+	forwardedHops   int
+	requestIDHeader string
+
+	methodExtractor func(*http.Request) (string, bool)
+	trailerFilter   func(string) bool
+	tracer          Tracer
+	pathRewrite     func(string) string
+
+	maxTrailerBytes int
+	exposedHeaders  []string
+
+	accessLog *accessLog
+	observer  func(RequestInfo)
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.logger == nil {
+		o.logger = nopLogger{}
+	}
+	if o.cors != nil {
+		o.cors.expose(o.exposedHeaders)
+	}
+	if o.clientConfig != nil {
+		o.clientConfig.describe(&o)
+	}
+
+	return o
+}
+
+// WithKnownMethods restricts the handler to the gRPC methods provided, in
+// their full "/package.Service/Method" form. Requests for any other method are
+// responded to with an UNIMPLEMENTED status, without being passed to the
+// wrapped handler.
+func WithKnownMethods(methods []string) Option {
+	return func(o *options) {
+		o.knownMethods = make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			if !strings.HasPrefix(method, "/") {
+				method = "/" + method
+			}
+			o.knownMethods[method] = struct{}{}
+		}
+	}
+}
+
+// WithMethodFilter restricts gRPC-Web requests to the gRPC methods that allow
+// returns true for, called with each method's full "/package.Service/Method"
+// name. Requests for any other method are responded to with a
+// PERMISSION_DENIED status, without being passed to the wrapped handler.
+// Native gRPC requests aren't filtered.
+func WithMethodFilter(allow func(fullMethod string) bool) Option {
+	return func(o *options) {
+		o.methodFilter = allow
+	}
+}
+
+func (o *options) isKnownMethod(method string) bool {
+	if o.knownMethods == nil {
+		return true
+	}
+
+	_, ok := o.knownMethods[method]
+	return ok
+}
+
+// withUnaryMethods sets the methods known to be unary, whose responses can be
+// buffered and written in one go.
+func withUnaryMethods(methods []string) Option {
+	return func(o *options) {
+		o.unaryMethods = make(map[string]struct{}, len(methods))
+		for _, method := range methods {
+			o.unaryMethods[method] = struct{}{}
+		}
+	}
+}
+
+func (o *options) isUnaryMethod(method string) bool {
+	_, ok := o.unaryMethods[method]
+	return ok
+}
+
+// WithBackendHealthCheck checks the health of the named service using the gRPC
+// health checking protocol, at most once per interval. Whilst the service
+// isn't SERVING, gRPC-Web requests are rejected with a 503 response carrying an
+// UNAVAILABLE status and a Retry-After of the interval, rather than being
+// passed to a backend that's draining. Checks are made independently of the
+// requests that trigger them, and time out after a second, or the interval if
+// shorter. Only the first check holds up the request that triggers it.
+func WithBackendHealthCheck(client healthpb.HealthClient, service string, interval time.Duration) Option {
+	return func(o *options) {
+		o.health = &healthChecker{client: client, service: service, interval: interval, serving: true}
+	}
+}
+
+// WithAuditHook calls hook once each audited request has completed, with a
+// copy of the messages sent in each direction. Captured messages are limited
+// in size, so streaming calls are never buffered in their entirety. Which
+// requests are audited is configured with WithAuditConfig.
+func WithAuditHook(hook func(AuditRecord)) Option {
+	return func(o *options) {
+		if o.audit == nil {
+			o.audit = &auditor{}
+		}
+		o.audit.hook = hook
+	}
+}
+
+// WithAuditConfig configures the requests captured by an audit hook and how
+// their records are redacted.
+func WithAuditConfig(config AuditConfig) Option {
+	return func(o *options) {
+		if o.audit == nil {
+			o.audit = &auditor{}
+		}
+		o.audit.config = config
+	}
+}
+
+// WithBypassPaths serves requests with a path beginning with any of the
+// prefixes using handler, ahead of any gRPC or gRPC-Web routing, regardless of
+// their content-type. This is useful for health check and probe endpoints. It
+// only applies to RootHandler.
+func WithBypassPaths(handler http.Handler, prefixes ...string) Option {
+	return func(o *options) {
+		o.bypassHandler = handler
+		o.bypassPrefixes = prefixes
+	}
+}
+
+func (o *options) bypasses(req *http.Request) bool {
+	if o.bypassHandler == nil {
+		return false
+	}
+
+	for _, prefix := range o.bypassPrefixes {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithGRPCDetector overrides how native gRPC requests are recognised, with
+// detect returning whether a request is for the gRPC handler, such as to route
+// requests with an application/grpc content-type that belong to another API to
+// the fallback. gRPC-Web requests are still recognised by their content-type.
+// It only applies to RootHandler.
+//
+// By default, requests are detected with IsGRPCRequest.
+func WithGRPCDetector(detect func(*http.Request) bool) Option {
+	return func(o *options) {
+		o.grpcDetector = detect
+	}
+}
+
+func (o *options) isGRPCRequest(req *http.Request) bool {
+	if o.grpcDetector != nil {
+		return o.grpcDetector(req)
+	}
+
+	return IsGRPCRequest(req)
+}
+
+// WithMaxConcurrent limits the number of gRPC-Web requests handled at once to
+// n. Requests beyond the limit aren't queued, but are responded to with a
+// RESOURCE_EXHAUSTED status. Native gRPC requests aren't limited. If n is zero
+// or less, requests aren't limited either.
+func WithMaxConcurrent(n int) Option {
+	return func(o *options) {
+		o.inFlight = nil
+		if n > 0 {
+			o.inFlight = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithMaxResponseBytes limits the messages written in response to a gRPC-Web
+// request to n bytes, counted before any base64 encoding. The message that
+// would exceed the limit isn't written, and the response instead ends with a
+// RESOURCE_EXHAUSTED status.
+func WithMaxResponseBytes(n int64) Option {
+	return func(o *options) {
+		o.maxResponseBytes = n
+	}
+}
+
+// defaultMaxBufferedResponse is the most of a unary response that's buffered
+// by default.
+const defaultMaxBufferedResponse = 64 << 10
+
+// WithMaxBufferedResponse limits the unary responses HandlerForServer buffers
+// to n bytes, as written to the client. A response that would exceed the limit
+// has what's buffered written, and the rest streamed, so that a large
+// response isn't held in memory in full. If n is zero or less, the default of
+// 64KiB is used.
+func WithMaxBufferedResponse(n int) Option {
+	return func(o *options) {
+		o.maxBufferedResponse = n
+	}
+}
+
+func (o *options) maxBufferedBytes() int {
+	if o.maxBufferedResponse <= 0 {
+		return defaultMaxBufferedResponse
+	}
+
+	return o.maxBufferedResponse
+}
+
+// WithMaxFrameBytes limits the messages of gRPC-Web requests to n bytes, once
+// decompressed. A request with a frame that declares a longer message is
+// responded to with an INVALID_ARGUMENT status, without the message being
+// read, so the wrapped handler never allocates for it.
+func WithMaxFrameBytes(n int64) Option {
+	return func(o *options) {
+		o.maxFrameBytes = n
+	}
+}
+
+// WithRequireGrpcWebHeader rejects gRPC-Web requests without an X-Grpc-Web
+// header, as sent by gRPC-Web clients, with 403 Forbidden. As the header can't
+// be set by a cross-origin form or request without a CORS preflight, this
+// protects against cross-site request forgery.
+func WithRequireGrpcWebHeader() Option {
+	return func(o *options) {
+		o.requireGRPCWebHeader = true
+	}
+}
+
+// WithStrictAccept responds to gRPC-Web requests with an Accept header that
+// doesn't include an acceptable gRPC-Web content-type with 406 Not Acceptable,
+// rather than a response encoded the same way as the request. Requests without
+// an Accept header are unaffected.
+func WithStrictAccept() Option {
+	return func(o *options) {
+		o.strictAccept = true
+	}
+}
+
+// WithRequireFlusher fails gRPC-Web responses that are streamed to a
+// http.ResponseWriter that isn't a http.Flusher with an INTERNAL status, once
+// the handler first writes, rather than holding their messages until the
+// response completes. Either way, an error is logged. Buffered responses to
+// unary methods are unaffected.
+func WithRequireFlusher() Option {
+	return func(o *options) {
+		o.requireFlusher = true
+	}
+}
+
+// WithContinuousText encodes text responses as one continuous base64 stream,
+// padded only at its end, rather than padding what's been written each time
+// the response is flushed. This suits clients that decode the response
+// incrementally with a single decoder, at the cost of the last one or two
+// bytes of each flushed message being held until more of the response is
+// written: base64 encodes groups of three bytes, and a partial group can't be
+// sent without padding.
+func WithContinuousText() Option {
+	return func(o *options) {
+		o.continuousText = true
+	}
+}
+
+// WithUnencodedText accepts requests with a text content-type whose bodies
+// are gRPC frames that haven't been base64 encoded, as sent by some proxies
+// that transcode requests imperfectly. Responses are still encoded as the
+// request's content-type and Accept header ask. By default, such requests are
+// rejected as invalid base64.
+func WithUnencodedText() Option {
+	return func(o *options) {
+		o.unencodedText = true
+	}
+}
+
+// WithContentLength preserves the Content-Length of gRPC-Web requests passed
+// to the wrapped handler, for handlers behind intermediaries that require it,
+// rather than removing it so that the body is streamed. Base64 encoded bodies
+// of up to 1MiB are decoded up front to determine their decoded length. The
+// Content-Length is still removed where the length can't be known, such as
+// for larger text requests and compressed requests.
+func WithContentLength() Option {
+	return func(o *options) {
+		o.contentLength = true
+	}
+}
+
+// WithoutProtocolRewrite passes gRPC-Web requests to the wrapped handler
+// with the protocol version and headers they arrived with, rather than
+// converting them to HTTP/2 requests: the protocol version isn't changed, TE
+// isn't set, and hop-by-hop headers aren't removed. The Content-Length of
+// binary requests is preserved unless they're decompressed. Only the
+// content-type and body are translated.
+//
+// This is for advanced setups, where the wrapped handler expects the original
+// HTTP/1.1 request, such as a transcoding layer. gRPC handlers, including a
+// grpc.Server, require HTTP/2 requests, and so can't be used with it.
+func WithoutProtocolRewrite() Option {
+	return func(o *options) {
+		o.noProtocolRewrite = true
+	}
+}
+
+// WithRequestTimeout limits how long a request can run for to d, by applying
+// it as a deadline to the request's context, so that no request runs forever.
+// With RootHandler, it applies to gRPC, gRPC-Web and fallback requests alike.
+// For gRPC-Web requests, the shorter of d and the client's grpc-timeout is
+// used, and passed on to the wrapped handler as its grpc-timeout.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.requestTimeout = d
+	}
+}
+
+// WithHeaderTimeout cancels the context of gRPC-Web requests whose handler
+// hasn't started responding, by writing or flushing, within d, and responds
+// with a DEADLINE_EXCEEDED status. Unlike the grpc-timeout the client sends,
+// it only applies until the response has started, so streams that respond
+// promptly can run for as long as they need, while stuck backends are
+// detected quickly.
+func WithHeaderTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.headerTimeout = d
+	}
+}
+
+// WithLogger logs request failures, such as request bodies that can't be
+// decoded and trailers that can't be written, to l. By default, nothing is
+// logged.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithErrorHandler calls fn when the request body of a gRPC-Web request can't
+// be decoded, or the response can't be written, which usually means the
+// client has disconnected. It's called at most once per request for failed
+// writes.
+func WithErrorHandler(fn func(*http.Request, error)) Option {
+	return func(o *options) {
+		o.errorHandler = fn
+	}
+}
+
+// WithMethodExtractor overrides how the full gRPC method name
+// ("/package.Service/Method") of a request is determined, for use where
+// request paths have been rewritten. The method is used by every option that
+// applies to specific methods. If fn returns false, the request is responded
+// to with an UNIMPLEMENTED status.
+//
+// By default, the method is the request's URL path.
+func WithMethodExtractor(fn func(*http.Request) (string, bool)) Option {
+	return func(o *options) {
+		o.methodExtractor = fn
+	}
+}
+
+// WithPathRewrite rewrites the URL path of each gRPC-Web request with fn
+// before it's handled, such as to strip the prefix of a path the handler is
+// mounted under. Options that apply to specific methods see the rewritten
+// path. Native gRPC requests aren't rewritten, but the gRPC handler can be
+// wrapped to rewrite them too.
+func WithPathRewrite(fn func(path string) string) Option {
+	return func(o *options) {
+		o.pathRewrite = fn
+	}
+}
+
+func (o *options) method(req *http.Request) (string, bool) {
+	if o.methodExtractor != nil {
+		return o.methodExtractor(req)
+	}
+
+	return req.URL.Path, true
+}
+
+// WithTrailerFilter calls keep with the lowercase name of each trailer the
+// handler responds with, and leaves out of the trailer frame those it returns
+// false for. The grpc-status and grpc-message trailers are always kept.
+func WithTrailerFilter(keep func(key string) bool) Option {
+	return func(o *options) {
+		o.trailerFilter = keep
+	}
+}
+
+// WithMaxTrailerBytes limits the trailers of gRPC-Web responses to n bytes, as
+// serialized in the trailer frame. If the trailers exceed the limit, all but
+// the grpc-status and grpc-message trailers are left out, and an error is
+// logged. By default, trailers aren't limited.
+func WithMaxTrailerBytes(n int) Option {
+	return func(o *options) {
+		o.maxTrailerBytes = n
+	}
+}
+
+// limitTrailers removes all but the status trailers if the trailers exceed
+// the limit.
+func (o *options) limitTrailers(trailers http.Header, log requestLogger) {
+	if o.maxTrailerBytes <= 0 {
+		return
+	}
+
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+
+	writeTrailerFields(buf, trailers)
+	if buf.Len() <= o.maxTrailerBytes {
+		return
+	}
+
+	log.errorf("trailers of %d bytes exceed the maximum of %d bytes, leaving out all but the status", buf.Len(), o.maxTrailerBytes)
+	for key := range trailers {
+		switch strings.ToLower(key) {
+		case headerGRPCStatus, "grpc-message":
+		default:
+			delete(trailers, key)
+		}
+	}
+}
+
+// filterTrailers removes the trailers that the trailer filter doesn't keep.
+func (o *options) filterTrailers(trailers http.Header) {
+	if o.trailerFilter == nil {
+		return
+	}
+
+	for key := range trailers {
+		name := strings.ToLower(key)
+		switch name {
+		case headerGRPCStatus, "grpc-message":
+			continue
+		}
+
+		if !o.trailerFilter(name) {
+			delete(trailers, key)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package grpcweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"google.golang.org/grpc"
+)
+
+// reflection serves the services registered with a gRPC server.
+type reflection struct {
+	path   string
+	server *grpc.Server
+}
+
+// ReflectionService describes a service served by a gRPC server, as served
+// by the endpoint added by WithReflection.
+type ReflectionService struct {
+	Name    string             `json:"name"`
+	Methods []ReflectionMethod `json:"methods"`
+}
+
+// ReflectionMethod describes a method of a ReflectionService.
+type ReflectionMethod struct {
+	Name            string `json:"name"`
+	Path            string `json:"path"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+// WithReflection serves the names of the services and methods registered with
+// the gRPC server as a JSON array of ReflectionService at the path provided,
+// so that browser tooling can discover the methods that can be called without
+// the gRPC reflection protocol. Like WithMetricsEndpoint, only GET and HEAD
+// requests that aren't gRPC or gRPC-Web requests are served by the endpoint.
+func WithReflection(s *grpc.Server, path string) Option {
+	return func(o *options) {
+		o.reflection = &reflection{path: path, server: s}
+	}
+}
+
+func (r *reflection) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	services := []ReflectionService{}
+	for name, info := range r.server.GetServiceInfo() {
+		service := ReflectionService{Name: name, Methods: []ReflectionMethod{}}
+		for _, method := range info.Methods {
+			service.Methods = append(service.Methods, ReflectionMethod{
+				Name:            method.Name,
+				Path:            "/" + name + "/" + method.Name,
+				ClientStreaming: method.IsClientStream,
+				ServerStreaming: method.IsServerStream,
+			})
+		}
+		sort.Slice(service.Methods, func(i, j int) bool {
+			return service.Methods[i].Name < service.Methods[j].Name
+		})
+
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	resp.Header().Set(headerContentType, "application/json")
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	json.NewEncoder(resp).Encode(services)
+}
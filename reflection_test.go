@@ -0,0 +1,43 @@
+package grpcweb_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+func TestReflection(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server, grpcweb.WithReflection(server, "/grpcweb.services"))
+
+	req := httptest.NewRequest("GET", "/grpcweb.services", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("content-type"))
+
+	var services []grpcweb.ReflectionService
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &services))
+	assert.Len(t, services, 1)
+	assert.Equal(t, "grpc.testing.TestService", services[0].Name)
+	assert.Contains(t, services[0].Methods, grpcweb.ReflectionMethod{
+		Name: "EmptyCall",
+		Path: "/grpc.testing.TestService/EmptyCall",
+	})
+	assert.Contains(t, services[0].Methods, grpcweb.ReflectionMethod{
+		Name:            "FullDuplexCall",
+		Path:            "/grpc.testing.TestService/FullDuplexCall",
+		ClientStreaming: true,
+		ServerStreaming: true,
+	})
+}
@@ -0,0 +1,48 @@
+package grpcweb
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// WithRequestIDHeader correlates each gRPC-Web request by the ID in the
+// request header name, such as "X-Request-Id". A request without one is
+// given a generated UUID, which is forwarded to the gRPC handler as metadata
+// in the same header. The ID is responded with in the header, exposed to
+// browser clients with WithCORS, and set in the request's RequestInfo.
+func WithRequestIDHeader(name string) Option {
+	return func(o *options) {
+		o.requestIDHeader = http.CanonicalHeaderKey(name)
+		o.exposedHeaders = append(o.exposedHeaders, name)
+	}
+}
+
+// requestID returns the ID of the request, generating and setting one in the
+// request header if it doesn't have one, and sets it in the response header.
+func (o *options) requestID(header http.Header, req *http.Request) string {
+	if o.requestIDHeader == "" {
+		return ""
+	}
+
+	id := req.Header.Get(o.requestIDHeader)
+	if id == "" {
+		id = newUUID()
+		req.Header.Set(o.requestIDHeader, id)
+	}
+	header.Set(o.requestIDHeader, id)
+
+	return id
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
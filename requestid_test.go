@@ -0,0 +1,58 @@
+package grpcweb_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDHeader(t *testing.T) {
+	var forwarded, observed string
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		forwarded = strings.Join(md.Get("x-request-id"), ",")
+
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	handler := grpcweb.Handler(server,
+		grpcweb.WithRequestIDHeader("x-request-id"),
+		grpcweb.WithCORS(grpcweb.CORSConfig{}),
+		grpcweb.WithRequestObserver(func(info grpcweb.RequestInfo) {
+			observed = info.RequestID
+		}),
+	)
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, id := range []string{"", "abc-123"} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", strings.NewReader("AAAAAAA="))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWebText)
+		req.Header.Set("origin", "https://example.com")
+		if id != "" {
+			req.Header.Set("x-request-id", id)
+		}
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+
+		responded := resp.Header().Get("x-request-id")
+		if id == "" {
+			assert.Regexp(t, uuid, responded)
+		} else {
+			assert.Equal(t, id, responded)
+		}
+		assert.Equal(t, responded, forwarded)
+		assert.Equal(t, responded, observed)
+		assert.Contains(t, resp.Header().Get("access-control-expose-headers"), "x-request-id")
+	}
+}
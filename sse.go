@@ -0,0 +1,52 @@
+package grpcweb
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+)
+
+// contentTypeEventStream is the content-type of Server-Sent Events.
+const contentTypeEventStream = "text/event-stream"
+
+// WithSSE responds to gRPC-Web requests whose Accept header includes
+// text/event-stream with Server-Sent Events, for networks whose proxies only
+// stream event streams. Each frame of the response, including the trailer
+// frame, is base64 encoded as the data of its own event, whatever the request
+// content-type. It's experimental, as gRPC-Web clients don't support it
+// without a custom transport.
+func WithSSE() Option {
+	return func(o *options) {
+		o.sse = true
+	}
+}
+
+// sseEncoder writes each complete frame written to it as an event, holding
+// frames written in part until the rest of them is written.
+type sseEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *sseEncoder) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+
+	for len(e.buf) >= FrameHeaderLength {
+		length := FrameHeaderLength + int(binary.BigEndian.Uint32(e.buf[1:FrameHeaderLength]))
+		if len(e.buf) < length {
+			break
+		}
+
+		event := make([]byte, 0, len("data: \n\n")+base64.StdEncoding.EncodedLen(length))
+		event = append(event, "data: "...)
+		event = append(event, base64.StdEncoding.EncodeToString(e.buf[:length])...)
+		event = append(event, "\n\n"...)
+		if _, err := e.w.Write(event); err != nil {
+			return 0, err
+		}
+
+		e.buf = append(e.buf[:0], e.buf[length:]...)
+	}
+
+	return len(p), nil
+}
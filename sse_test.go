@@ -0,0 +1,61 @@
+package grpcweb_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSE(t *testing.T) {
+	msg := []byte{0x00, 0x00, 0x00, 0x00, 0x02, 0x08, 0x01}
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+
+		// frames written in part are held until they're complete
+		resp.Write(msg[:3])
+		resp.(http.Flusher).Flush()
+		resp.Write(msg[3:])
+		resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	for name, test := range map[string]struct {
+		opts        []grpcweb.Option
+		accept      string
+		contentType string
+		expected    string
+	}{
+		"events": {
+			[]grpcweb.Option{grpcweb.WithSSE()},
+			"text/event-stream",
+			"text/event-stream",
+			"data: " + base64.StdEncoding.EncodeToString(msg) + "\n\n" +
+				"data: " + base64.StdEncoding.EncodeToString(trailerFrame("grpc-status: 0\r\n")) + "\n\n",
+		},
+		"not acceptable": {
+			[]grpcweb.Option{grpcweb.WithSSE()},
+			"text/event-stream;q=0",
+			grpcweb.ContentTypeGRPCWeb,
+			string(append(append([]byte{}, msg...), trailerFrame("grpc-status: 0\r\n")...)),
+		},
+		"disabled": {
+			nil,
+			"text/event-stream",
+			grpcweb.ContentTypeGRPCWeb,
+			string(append(append([]byte{}, msg...), trailerFrame("grpc-status: 0\r\n")...)),
+		},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/StreamingOutputCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("accept", test.accept)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, test.opts...).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.contentType, rec.Header().Get("content-type"), name)
+		assert.Equal(t, test.expected, rec.Body.String(), name)
+	}
+}
@@ -0,0 +1,82 @@
+package grpcweb
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// WriteStatus writes a gRPC-Web response to w consisting only of a trailer
+// frame, base64 encoded if text is true, with the status and trailing metadata
+// provided. This is useful for rejecting requests before they reach a gRPC
+// handler, such as when rate limiting.
+//
+// Binary metadata, with keys ending in "-bin", is base64 encoded, as it is by
+// gRPC.
+func WriteStatus(w http.ResponseWriter, code codes.Code, msg string, md metadata.MD, text bool) {
+	trailers := make(http.Header)
+	for key, values := range md {
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.RawStdEncoding.EncodeToString([]byte(value))
+			}
+			trailers.Add(key, value)
+		}
+	}
+	setStatus(trailers, code, msg)
+
+	NewResponseWriter(w, text).WriteTrailers(trailers)
+}
+
+// StatusRecorder records the gRPC status that a gRPC-Web response completed
+// with, for middleware wrapping the handler, such as for metrics or tracing.
+type StatusRecorder struct {
+	// Code is the status code, which is Unknown if the response had none.
+	Code codes.Code
+
+	// Message is the status message, decoded from its percent-encoding.
+	Message string
+
+	// Incomplete is true if the response couldn't be completely written,
+	// usually because the client disconnected, in which case the client may
+	// not have received the status.
+	Incomplete bool
+}
+
+type statusRecorderKey struct{}
+
+// ContextWithStatusRecorder returns a copy of ctx with the recorder provided.
+// When a gRPC-Web request with the context is handled, the status of the
+// response is recorded by rec once the response has been written.
+func ContextWithStatusRecorder(ctx context.Context, rec *StatusRecorder) context.Context {
+	return context.WithValue(ctx, statusRecorderKey{}, rec)
+}
+
+// recordStatus records the status of the trailers provided, and whether the
+// response was complete, with the context's recorder, if it has one.
+func recordStatus(ctx context.Context, trailers http.Header, complete bool) {
+	rec, ok := ctx.Value(statusRecorderKey{}).(*StatusRecorder)
+	if !ok {
+		return
+	}
+
+	rec.Code = statusCode(trailers)
+	rec.Message = decodeGRPCMessage(trailers.Get("Grpc-Message"))
+	rec.Incomplete = !complete
+}
+
+// statusCode returns the status code of the trailers, or Unknown if they
+// don't have a valid one.
+func statusCode(trailers http.Header) codes.Code {
+	code, err := strconv.ParseUint(trailers.Get(headerGRPCStatus), 10, 32)
+	if err != nil {
+		return codes.Unknown
+	}
+
+	return codes.Code(code)
+}
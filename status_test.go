@@ -0,0 +1,97 @@
+package grpcweb_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWriteStatus(t *testing.T) {
+	md := metadata.Pairs("retry-after-ms", "500", "hint-bin", "\x00\x01")
+	expected := trailerFrame("grpc-message: slow down\r\ngrpc-status: 8\r\nhint-bin: AAE\r\nretry-after-ms: 500\r\n")
+
+	rec := httptest.NewRecorder()
+	grpcweb.WriteStatus(rec, codes.ResourceExhausted, "slow down", md, false)
+
+	assert.Equal(t, grpcweb.ContentTypeGRPCWeb, rec.Header().Get("content-type"))
+	assert.Equal(t, expected, rec.Body.Bytes())
+
+	rec = httptest.NewRecorder()
+	grpcweb.WriteStatus(rec, codes.ResourceExhausted, "slow down", md, true)
+
+	assert.Equal(t, grpcweb.ContentTypeGRPCWebText, rec.Header().Get("content-type"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(expected), rec.Body.String())
+}
+
+func TestStatusRecorder(t *testing.T) {
+	server := grpc.NewServer()
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	var recorded []grpcweb.StatusRecorder
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &grpcweb.StatusRecorder{}
+			next.ServeHTTP(w, r.WithContext(grpcweb.ContextWithStatusRecorder(r.Context(), rec)))
+			recorded = append(recorded, *rec)
+		})
+	}
+
+	encoded := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Header().Set("Grpc-Status", "10")
+		w.Header().Set("Grpc-Message", "50%25 done")
+	})
+
+	for _, test := range []struct {
+		handler http.Handler
+		method  string
+	}{
+		{grpcweb.Handler(server), "EmptyCall"},
+		{grpcweb.Handler(server), "UnimplementedCall"},
+		{grpcweb.Handler(encoded), "EmptyCall"},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/"+test.method, strings.NewReader("\x00\x00\x00\x00\x00"))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		middleware(test.handler).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []grpcweb.StatusRecorder{
+		{Code: codes.OK},
+		{Code: codes.Unimplemented, Message: "method UnimplementedCall not implemented"},
+		{Code: codes.Aborted, Message: "50% done"},
+	}, recorded)
+}
+
+func TestStatusRecorderIncomplete(t *testing.T) {
+	handler := grpcweb.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0x00, 0x00, 0x00, 0x00, 0x00})
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+
+	for name, test := range map[string]struct {
+		w          http.ResponseWriter
+		incomplete bool
+	}{
+		"complete":   {httptest.NewRecorder(), false},
+		"incomplete": {&failingWriter{header: make(http.Header)}, true},
+	} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := &grpcweb.StatusRecorder{}
+		handler.ServeHTTP(test.w, req.WithContext(grpcweb.ContextWithStatusRecorder(req.Context(), rec)))
+
+		assert.Equal(t, grpcweb.StatusRecorder{Code: codes.OK, Incomplete: test.incomplete}, *rec, name)
+	}
+}
@@ -0,0 +1,55 @@
+package grpcweb
+
+import "net/http"
+
+// WithStrict enforces the gRPC-Web specification rigorously, for clients that
+// tolerate nothing else. In strict mode:
+//
+//   - Requests must be POST. Requests with any other method are responded to
+//     with an UNIMPLEMENTED status, without being passed to the wrapped
+//     handler.
+//   - Responses are always HTTP 200, with their status in the trailer frame.
+//     Requests rejected with another HTTP status, such as by
+//     WithRequireGrpcWebHeader, WithStrictAccept or WithBackendHealthCheck,
+//     are responded to with their status in the trailer frame instead.
+//   - Responses the handler writes that aren't gRPC responses, such as
+//     redirects and error pages, are discarded rather than passed through,
+//     and responded to with a status derived from their HTTP status, or
+//     UNKNOWN.
+//   - The grpc-message trailer the handler responds with is percent-encoded,
+//     as gRPC requires, even if the handler didn't encode it.
+//
+// Whether or not it's enabled, only requests with a content-type exactly
+// matching one of the ContentTypeGRPCWeb constants are handled as gRPC-Web
+// requests, and responses always have a grpc-status trailer and lowercase
+// trailer names. HEAD requests are responded to with HTTP 405 and an Allow
+// header, as their response can't have a trailer frame.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// reject responds to a request refused before the handler is called with the
+// HTTP status provided, and the status in trailers sent as headers, as a
+// trailers-only response. In strict mode, the response is HTTP 200, with the
+// status in the trailer frame instead.
+func (h *grpcWebHandler) reject(w *gRPCWebResponseWriter, trailers http.Header, statusCode int) {
+	if h.opts.strict {
+		w.WriteTrailers(trailers)
+		return
+	}
+
+	for key, val := range trailers {
+		w.Header()[key] = val
+	}
+	w.writeHeader(statusCode)
+}
+
+// normalizeMessage percent-encodes the grpc-message trailer, decoding it first
+// so that a message that's already encoded isn't encoded twice.
+func normalizeMessage(trailers http.Header) {
+	if msg := trailers.Get("Grpc-Message"); msg != "" {
+		trailers.Set("Grpc-Message", encodeGRPCMessage(decodeGRPCMessage(msg)))
+	}
+}
@@ -0,0 +1,107 @@
+package grpcweb_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		called = true
+
+		switch req.URL.Path {
+		case "/grpc.testing.TestService/UnaryCall":
+			resp.Header().Set("content-type", "text/html")
+			resp.Header().Set("content-length", "9")
+			resp.WriteHeader(http.StatusNotFound)
+			resp.Write([]byte("not found"))
+
+		case "/grpc.testing.TestService/EmptyCall":
+			resp.Header().Set("content-type", grpcweb.ContentTypeGRPC)
+			resp.Header().Set(http.TrailerPrefix+"Grpc-Status", "2")
+			resp.Header().Set(http.TrailerPrefix+"Grpc-Message", "café 100%")
+		}
+	})
+
+	for name, test := range map[string]struct {
+		method   string
+		path     string
+		opts     []grpcweb.Option
+		called   bool
+		expected []byte
+	}{
+		"only POST": {
+			"GET",
+			"/grpc.testing.TestService/EmptyCall",
+			nil,
+			false,
+			trailerFrame("grpc-message: gRPC-Web requests must be POST, not GET\r\ngrpc-status: 12\r\n"),
+		},
+		"always 200": {
+			"POST",
+			"/grpc.testing.TestService/EmptyCall",
+			[]grpcweb.Option{grpcweb.WithRequireGrpcWebHeader()},
+			false,
+			trailerFrame("grpc-message: missing x-grpc-web header\r\ngrpc-status: 7\r\n"),
+		},
+		"non-gRPC responses discarded": {
+			"POST",
+			"/grpc.testing.TestService/UnaryCall",
+			nil,
+			true,
+			trailerFrame("grpc-message: handler responded with content-type \"text/html\"\r\ngrpc-status: 12\r\n"),
+		},
+		"messages percent-encoded": {
+			"POST",
+			"/grpc.testing.TestService/EmptyCall",
+			nil,
+			true,
+			trailerFrame("grpc-message: caf%C3%A9 100%25\r\ngrpc-status: 2\r\n"),
+		},
+	} {
+		called = false
+
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, append([]grpcweb.Option{grpcweb.WithStrict()}, test.opts...)...).ServeHTTP(rec, req)
+
+		assert.Equal(t, test.called, called, name)
+		assert.Equal(t, http.StatusOK, rec.Code, name)
+		assert.Equal(t, grpcweb.ContentTypeGRPCWeb, rec.Header().Get("content-type"), name)
+		assert.Empty(t, rec.Header().Get("content-length"), name)
+		assert.Equal(t, test.expected, rec.Body.Bytes(), name)
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		called = true
+	})
+
+	for name, opts := range map[string][]grpcweb.Option{
+		"default": nil,
+		"strict":  {grpcweb.WithStrict()},
+	} {
+		called = false
+
+		req := httptest.NewRequest("HEAD", "/grpc.testing.TestService/EmptyCall", nil)
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+
+		rec := httptest.NewRecorder()
+		grpcweb.Handler(handler, opts...).ServeHTTP(rec, req)
+
+		assert.False(t, called, name)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, name)
+		assert.Equal(t, "POST", rec.Header().Get("allow"), name)
+		assert.Equal(t, "12", rec.Header().Get("grpc-status"), name)
+		assert.Empty(t, rec.Body.Bytes(), name)
+	}
+}
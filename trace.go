@@ -0,0 +1,34 @@
+package grpcweb
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Tracer traces the gRPC-Web requests handled, such as with OpenTelemetry,
+// without this package depending on a tracing library. The trace context sent
+// by browser clients, in traceparent and tracestate headers or a
+// grpc-trace-bin header, is left in the request's headers for the tracer to
+// extract.
+type Tracer interface {
+	// Start starts a span for the request of the method provided, which is
+	// a good span name, returning the span and a context derived from the
+	// request's carrying it. The wrapped handler is called with the context.
+	Start(req *http.Request, method string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// End ends the span with the gRPC status the response completed with.
+	End(code codes.Code, msg string)
+}
+
+// WithTracer starts a span with t around the handling of each gRPC-Web
+// request that reaches the wrapped handler.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
@@ -0,0 +1,83 @@
+package grpcweb_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/saracen/grpcweb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/interop"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	name    string
+	parent  string
+	code    codes.Code
+	msg     string
+	ended   bool
+	handled bool
+}
+
+func (s *testSpan) End(code codes.Code, msg string) {
+	s.code, s.msg, s.ended = code, msg, true
+}
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(req *http.Request, method string) (context.Context, grpcweb.Span) {
+	span := &testSpan{name: method, parent: req.Header.Get("traceparent")}
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(req.Context(), spanKey{}, span), span
+}
+
+func TestTracer(t *testing.T) {
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// the span is carried by the context gRPC handlers are called with
+		if span, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+			span.handled = true
+		}
+
+		return handler(ctx, req)
+	}))
+	testpb.RegisterTestServiceServer(server, interop.NewTestServer())
+
+	tracer := &testTracer{}
+	handler := grpcweb.Handler(server, grpcweb.WithTracer(tracer))
+
+	for _, method := range []string{"EmptyCall", "UnimplementedCall"} {
+		req := httptest.NewRequest("POST", "/grpc.testing.TestService/"+method, strings.NewReader("\x00\x00\x00\x00\x00"))
+		req.Header.Set("content-type", grpcweb.ContentTypeGRPCWeb)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(t, []*testSpan{
+		{
+			name:    "/grpc.testing.TestService/EmptyCall",
+			parent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			code:    codes.OK,
+			ended:   true,
+			handled: true,
+		},
+		{
+			name:    "/grpc.testing.TestService/UnimplementedCall",
+			parent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			code:    codes.Unimplemented,
+			msg:     "method UnimplementedCall not implemented",
+			ended:   true,
+			handled: true,
+		},
+	}, tracer.spans)
+}